@@ -0,0 +1,38 @@
+import { safeFetch } from '$lib/utils/fetch'
+import { apiRoutes } from '$lib/config'
+import { authToken } from '$lib/stores'
+import * as logger from '$lib/logging'
+
+export interface User {
+	id: number
+	username: string
+	role: 'admin' | 'member'
+}
+
+interface Session {
+	token: string
+	expiresAt: string
+	user: User
+}
+
+export async function login(username: string, password: string): Promise<boolean> {
+	const session = await safeFetch<Session>(apiRoutes.login.path, {
+		method: apiRoutes.login.method,
+		headers: { 'Content-Type': 'application/json' },
+		body: JSON.stringify({ username, password })
+	})
+
+	if (!session || typeof session !== 'object' || !session.token) {
+		logger.error('Login failed', { username })
+		return false
+	}
+
+	logger.info(`Logged in as ${session.user.username}`)
+	authToken.set(session.token)
+	return true
+}
+
+export async function logout() {
+	await safeFetch(apiRoutes.logout.path, { method: apiRoutes.logout.method })
+	authToken.set(null)
+}
@@ -6,6 +6,21 @@ export const apiRoutes = {
 		method: 'GET',
 		description: 'Check if the backend API is up and running'
 	},
+	login: {
+		path: baseUrl + '/api/auth/login',
+		method: 'POST',
+		description: 'Log in and receive a session token'
+	},
+	logout: {
+		path: baseUrl + '/api/auth/logout',
+		method: 'POST',
+		description: 'End the current session'
+	},
+	me: {
+		path: baseUrl + '/api/auth/me',
+		method: 'GET',
+		description: 'Get the logged in user'
+	},
 	getAllServers: {
 		path: baseUrl + '/api/servers',
 		method: 'GET',
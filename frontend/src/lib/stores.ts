@@ -22,3 +22,16 @@ export const ipinfo = writable<{
 })
 
 export const backendStatus = writable<'ok' | 'error'>('ok')
+
+const authTokenKey = 'authToken'
+
+// session token from logging in, kept across reloads; null when logged out
+export const authToken = writable<string | null>(localStorage.getItem(authTokenKey))
+
+authToken.subscribe((token) => {
+	if (token) {
+		localStorage.setItem(authTokenKey, token)
+	} else {
+		localStorage.removeItem(authTokenKey)
+	}
+})
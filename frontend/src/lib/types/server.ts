@@ -4,7 +4,7 @@ export interface Server {
 	name: string
 	port: number
 	host: string
-	status: 'online' | 'offline' | 'unknown'
+	status: 'online' | 'starting' | 'offline' | 'unknown'
 	description: string
 	version: string
 	createdAt: string
@@ -1,11 +1,29 @@
+import { get } from 'svelte/store'
 import { debug, error } from '$lib/logging'
+import { authToken } from '$lib/stores'
+
+function withAuth(options?: RequestInit): RequestInit {
+	const token = get(authToken)
+	if (!token) {
+		return options ?? {}
+	}
+
+	const headers = new Headers(options?.headers)
+	headers.set('Authorization', `Bearer ${token}`)
+	return { ...options, headers }
+}
 
 export async function safeFetch<T = unknown>(
 	url: string,
 	options?: RequestInit
 ): Promise<T | undefined> {
 	try {
-		const response = await fetch(url, options)
+		const response = await fetch(url, withAuth(options))
+
+		if (response.status === 401) {
+			// the session expired or was revoked, back to the login screen
+			authToken.set(null)
+		}
 
 		if (!response.ok) {
 			error(`fetch failed: ${response.status} ${response.statusText}`)
@@ -1,9 +1,18 @@
+import { get } from 'svelte/store'
+import { authToken } from '$lib/stores'
+
+// browsers cannot set headers on websocket upgrades, so the token goes in the query
+function withToken(url: string) {
+	const token = get(authToken)
+	return token ? `${url}?token=${encodeURIComponent(token)}` : url
+}
+
 export function toWebSocketUrl(baseUrl: string, channel: string) {
 	if (!baseUrl) {
 		const protocol = window.location.protocol === 'https:' ? 'wss' : 'ws'
-		return `${protocol}://${window.location.host}/channels/${channel}`
+		return withToken(`${protocol}://${window.location.host}/channels/${channel}`)
 	}
 
 	const wsUrl = baseUrl.replace(/^http/, 'ws')
-	return `${wsUrl}/channels/${channel}`
+	return withToken(`${wsUrl}/channels/${channel}`)
 }
@@ -2,12 +2,11 @@ package app
 
 import (
 	"github.com/gofiber/fiber/v2"
-	"os"
-	"os/signal"
-	"syscall"
 	"watercolormc/internal/app/channels"
 	"watercolormc/internal/app/middleware"
 	"watercolormc/internal/app/routes"
+	"watercolormc/internal/app/servers"
+	"watercolormc/internal/metrics"
 )
 
 func Init() *fiber.App {
@@ -19,14 +18,15 @@ func Init() *fiber.App {
 	middleware.Setup(app)
 	routes.Setup(app)
 	channels.Init(app)
+	channels.SetAuthorizer(servers.AuthorizeChannel)
 
+	metrics.RegisterCollector(channels.CollectMetrics)
+	metrics.RegisterCollector(servers.CollectMetrics)
+
+	sigChan := servers.ShutdownSignals()
 	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 		<-sigChan
-
-		channels.Cleanup()
-		os.Exit(0)
+		shutdown(app)
 	}()
 
 	return app
@@ -0,0 +1,197 @@
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+	"watercolormc/internal/database"
+)
+
+// results of an audited action
+const (
+	ResultOk    = "ok"
+	ResultError = "error"
+)
+
+// Entry is one recorded action. Entries are never updated or deleted; the table
+// rejects both.
+type Entry struct {
+	Id         int64                  `json:"id"`
+	Time       time.Time              `json:"time"`
+	UserId     *int64                 `json:"userId"`
+	Username   string                 `json:"username"`
+	TokenId    *int64                 `json:"tokenId,omitempty"`
+	ClientAddr string                 `json:"clientAddr"`
+	UserAgent  string                 `json:"userAgent"`
+	ServerId   string                 `json:"serverId,omitempty"`
+	Action     string                 `json:"action"`
+	Method     string                 `json:"method,omitempty"`
+	Path       string                 `json:"path,omitempty"`
+	Params     map[string]interface{} `json:"params,omitempty"`
+	Status     int                    `json:"status,omitempty"`
+	Result     string                 `json:"result"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Filter narrows a query. Zero fields match everything. Action matches exactly or,
+// when it ends in "*", by prefix.
+type Filter struct {
+	Username string
+	ServerId string
+	Action   string
+	Result   string
+	From     time.Time
+	To       time.Time
+	Before   int64
+	Limit    int
+}
+
+// keys whose values are never written to the log
+var sensitiveKeys = []string{"password", "token", "secret", "current"}
+
+// Redact replaces the values of sensitive parameters, at any depth
+func Redact(params map[string]interface{}) map[string]interface{} {
+	for key, value := range params {
+		lower := strings.ToLower(key)
+		redacted := false
+		for _, sensitive := range sensitiveKeys {
+			if strings.Contains(lower, sensitive) {
+				params[key] = "[redacted]"
+				redacted = true
+				break
+			}
+		}
+		if nested, ok := value.(map[string]interface{}); ok && !redacted {
+			params[key] = Redact(nested)
+		}
+	}
+	return params
+}
+
+// Record appends an entry. Failures are logged rather than returned so auditing
+// never breaks the action being audited.
+func Record(e Entry) {
+	db := database.Get()
+	if db == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	var params []byte
+	if len(e.Params) > 0 {
+		var err error
+		if params, err = json.Marshal(Redact(e.Params)); err != nil {
+			zap.L().Error("failed to encode audit params", zap.String("action", e.Action), zap.Error(err))
+		}
+	}
+
+	_, err := db.Client.Exec(`
+		INSERT INTO audit_log (created_at, user_id, username, token_id, client_addr, user_agent, server_id,
+			action, method, path, params, status, result, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Time.UnixMilli(), e.UserId, e.Username, e.TokenId, e.ClientAddr, e.UserAgent, e.ServerId,
+		e.Action, e.Method, e.Path, string(params), e.Status, e.Result, e.Error)
+	if err != nil {
+		zap.L().Error("failed to record audit entry", zap.String("action", e.Action), zap.Error(err))
+	}
+}
+
+// Query returns entries matching a filter, newest first
+func Query(f Filter) ([]Entry, error) {
+	entries := []Entry{}
+	err := Each(f, func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+// Each calls fn for every entry matching a filter, newest first, without loading
+// them all at once
+func Each(f Filter, fn func(Entry) error) error {
+	db := database.Get()
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+
+	query := `
+		SELECT id, created_at, user_id, username, token_id, client_addr, user_agent, server_id,
+			action, method, path, params, status, result, error
+		FROM audit_log WHERE 1 = 1`
+	var args []interface{}
+	if f.Username != "" {
+		query += ` AND username = ?`
+		args = append(args, f.Username)
+	}
+	if f.ServerId != "" {
+		query += ` AND server_id = ?`
+		args = append(args, f.ServerId)
+	}
+	if strings.HasSuffix(f.Action, "*") {
+		query += ` AND action LIKE ? ESCAPE '\'`
+		prefix := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.TrimSuffix(f.Action, "*"))
+		args = append(args, prefix+"%")
+	} else if f.Action != "" {
+		query += ` AND action = ?`
+		args = append(args, f.Action)
+	}
+	if f.Result != "" {
+		query += ` AND result = ?`
+		args = append(args, f.Result)
+	}
+	if !f.From.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, f.From.UnixMilli())
+	}
+	if !f.To.IsZero() {
+		query += ` AND created_at <= ?`
+		args = append(args, f.To.UnixMilli())
+	}
+	if f.Before > 0 {
+		query += ` AND id < ?`
+		args = append(args, f.Before)
+	}
+	query += ` ORDER BY id DESC`
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := db.Client.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Entry
+		var createdAt int64
+		var params string
+		var userId, tokenId sql.NullInt64
+		if err := rows.Scan(&e.Id, &createdAt, &userId, &e.Username, &tokenId, &e.ClientAddr, &e.UserAgent, &e.ServerId,
+			&e.Action, &e.Method, &e.Path, &params, &e.Status, &e.Result, &e.Error); err != nil {
+			return err
+		}
+		e.Time = time.UnixMilli(createdAt).UTC()
+		if userId.Valid {
+			e.UserId = &userId.Int64
+		}
+		if tokenId.Valid {
+			e.TokenId = &tokenId.Int64
+		}
+		if params != "" {
+			if err := json.Unmarshal([]byte(params), &e.Params); err != nil {
+				return err
+			}
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"strings"
+	"watercolormc/internal"
+	"watercolormc/internal/database"
+	"watercolormc/internal/utils"
+)
+
+const (
+	// AdminUsername is the account created on first run
+	AdminUsername = "admin"
+	// initialPasswordFile holds the generated admin password until it is changed
+	initialPasswordFile = "initial-admin-password"
+	minPasswordLength   = 8
+)
+
+// dummyHash is compared against when a username does not exist
+var dummyHash = []byte("$2a$10$zmL8pURz9hl75gi8Bk.14eDQ5lvDaQ0keDh9tSjdu9bSCxSxSANxK")
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserExists         = errors.New("user already exists")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters")
+	ErrUnknownRole        = errors.New("role must be admin or member")
+	ErrLastAdmin          = errors.New("cannot remove the last admin")
+)
+
+type User struct {
+	Id        int64  `json:"id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// userColumns are scanned by scanUser, in order
+const userColumns = `u.id, u.username, u.role, u.created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner, u *User, extra ...interface{}) error {
+	return row.Scan(append([]interface{}{&u.Id, &u.Username, &u.Role, &u.CreatedAt}, extra...)...)
+}
+
+// EnsureAdmin creates the admin account with a random password if no user exists
+// yet. The password is logged once and written next to the database so it can be
+// looked up later; the file is removed when the password is changed.
+func EnsureAdmin() error {
+	db := database.Get()
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+
+	var count int
+	if err := db.Client.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		// accounts created before roles existed default to member, which would leave
+		// nobody able to manage users
+		_, err := db.Client.Exec(`
+			UPDATE users SET role = ? WHERE id = (SELECT MIN(id) FROM users)
+			AND NOT EXISTS (SELECT 1 FROM users WHERE role = ?)`, RoleAdmin, RoleAdmin)
+		return err
+	}
+
+	password, err := randomToken(12)
+	if err != nil {
+		return err
+	}
+
+	// the password only ever goes to a file readable by the manager's user, never
+	// to the log
+	passwordFile := filepath.Join(utils.ExpandHome(internal.WatercolorDataDirectory), initialPasswordFile)
+	if err := os.WriteFile(passwordFile, []byte(password+"\n"), 0600); err != nil {
+		return err
+	}
+	if _, err := CreateUser(AdminUsername, password, RoleAdmin); err != nil {
+		_ = os.Remove(passwordFile)
+		return err
+	}
+
+	zap.L().Warn("created admin account, read its password from the file and change it after logging in",
+		zap.String("username", AdminUsername),
+		zap.String("file", passwordFile),
+	)
+	return nil
+}
+
+// CreateUser adds a user with a bcrypt hash of the password
+func CreateUser(username string, password string, role string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username is required")
+	}
+	if role != RoleAdmin && role != RoleMember {
+		return nil, ErrUnknownRole
+	}
+	if len(password) < minPasswordLength {
+		return nil, ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	db := database.Get()
+	var exists int
+	if err := db.Client.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ?`, username).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists > 0 {
+		return nil, ErrUserExists
+	}
+
+	if _, err := db.Client.Exec(`INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)`, username, string(hash), role); err != nil {
+		return nil, err
+	}
+
+	return GetUserByName(username)
+}
+
+// GetUserByName returns the user with the given username, or nil if there is none
+func GetUserByName(username string) (*User, error) {
+	var u User
+	err := scanUser(database.Get().Client.QueryRow(`SELECT `+userColumns+` FROM users u WHERE u.username = ?`, username), &u)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUser returns the user with the given id, or nil if there is none
+func GetUser(id int64) (*User, error) {
+	var u User
+	err := scanUser(database.Get().Client.QueryRow(`SELECT `+userColumns+` FROM users u WHERE u.id = ?`, id), &u)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ListUsers returns every user
+func ListUsers() ([]User, error) {
+	rows, err := database.Get().Client.Query(`SELECT ` + userColumns + ` FROM users u ORDER BY u.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := scanUser(rows, &u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetRole changes the global role of a user
+func SetRole(id int64, role string) error {
+	if role != RoleAdmin && role != RoleMember {
+		return ErrUnknownRole
+	}
+	if role != RoleAdmin {
+		if err := ensureOtherAdmin(id); err != nil {
+			return err
+		}
+	}
+
+	_, err := database.Get().Client.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	return err
+}
+
+// DeleteUser removes a user along with their sessions, tokens and grants
+func DeleteUser(id int64) error {
+	if err := ensureOtherAdmin(id); err != nil {
+		return err
+	}
+
+	tx, err := database.Get().Client.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM sessions WHERE user_id = ?`,
+		`DELETE FROM api_tokens WHERE user_id = ?`,
+		`DELETE FROM server_grants WHERE user_id = ?`,
+		`DELETE FROM users WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ensureOtherAdmin fails if id is the only admin left
+func ensureOtherAdmin(id int64) error {
+	var others int
+	err := database.Get().Client.QueryRow(`SELECT COUNT(*) FROM users WHERE role = ? AND id != ?`, RoleAdmin, id).Scan(&others)
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		if u, err := GetUser(id); err == nil && u != nil && u.Role == RoleAdmin {
+			return ErrLastAdmin
+		}
+	}
+	return nil
+}
+
+// Authenticate checks a username and password
+func Authenticate(username string, password string) (*User, error) {
+	var u User
+	var hash string
+	err := scanUser(database.Get().Client.QueryRow(`SELECT `+userColumns+`, u.password_hash FROM users u WHERE u.username = ?`, username), &u, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		// compare anyway so unknown users take as long as wrong passwords
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &u, nil
+}
+
+// ChangePassword replaces a user's password after checking the current one, and
+// ends every other session of the user
+func ChangePassword(user *User, current string, password string, keepSession string) error {
+	if _, err := Authenticate(user.Username, current); err != nil {
+		return err
+	}
+	if len(password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	db := database.Get()
+	if _, err := db.Client.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, string(hash), user.Id); err != nil {
+		return err
+	}
+	if _, err := db.Client.Exec(`DELETE FROM sessions WHERE user_id = ? AND token_hash != ?`, user.Id, hashToken(keepSession)); err != nil {
+		return err
+	}
+
+	if user.Username == AdminUsername {
+		passwordFile := filepath.Join(utils.ExpandHome(internal.WatercolorDataDirectory), initialPasswordFile)
+		if err := os.Remove(passwordFile); err != nil && !os.IsNotExist(err) {
+			zap.L().Warn("failed to remove initial admin password", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func randomToken(size int) (string, error) {
+	secret := make([]byte, size)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
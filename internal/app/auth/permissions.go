@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"errors"
+	"sort"
+	"watercolormc/internal/database"
+)
+
+// Permission is something a user may be granted on a single server
+type Permission string
+
+const (
+	PermView     Permission = "view"
+	PermConsole  Permission = "console"
+	PermPower    Permission = "power"
+	PermFiles    Permission = "files"
+	PermBackups  Permission = "backups"
+	PermPlugins  Permission = "plugins"
+	PermSettings Permission = "settings"
+	PermDelete   Permission = "delete"
+)
+
+var AllPermissions = []Permission{PermView, PermConsole, PermPower, PermFiles, PermBackups, PermPlugins, PermSettings, PermDelete}
+
+// global roles. Admins may do anything on every server and manage users; members
+// only see the servers they were granted.
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// GrantPresets are shorthands for common sets of permissions
+var GrantPresets = map[string][]Permission{
+	"viewer":   {PermView},
+	"operator": {PermView, PermConsole, PermPower},
+	"manager":  {PermView, PermConsole, PermPower, PermFiles, PermBackups, PermPlugins, PermSettings},
+	"owner":    AllPermissions,
+}
+
+var ErrUnknownPermission = errors.New("unknown permission")
+
+// Grant lists the permissions a user has on a server
+type Grant struct {
+	UserId      int64        `json:"userId"`
+	Username    string       `json:"username"`
+	ServerId    string       `json:"serverId"`
+	Permissions []Permission `json:"permissions"`
+}
+
+func isPermission(p Permission) bool {
+	for _, known := range AllPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandGrant validates permissions and adds those of a preset. Every grant
+// includes view, as nothing else is usable without seeing the server.
+func ExpandGrant(preset string, permissions []Permission) ([]Permission, error) {
+	set := map[Permission]bool{PermView: true}
+	if preset != "" {
+		presetPerms, ok := GrantPresets[preset]
+		if !ok {
+			return nil, errors.New("unknown preset " + preset)
+		}
+		for _, p := range presetPerms {
+			set[p] = true
+		}
+	}
+	for _, p := range permissions {
+		if !isPermission(p) {
+			return nil, ErrUnknownPermission
+		}
+		set[p] = true
+	}
+
+	expanded := make([]Permission, 0, len(set))
+	for _, p := range AllPermissions {
+		if set[p] {
+			expanded = append(expanded, p)
+		}
+	}
+	return expanded, nil
+}
+
+// Can reports whether a user holds a permission on a server
+func Can(user *User, serverId string, permission Permission) (bool, error) {
+	if user.Role == RoleAdmin {
+		return true, nil
+	}
+
+	var count int
+	err := database.Get().Client.QueryRow(`
+		SELECT COUNT(*) FROM server_grants WHERE user_id = ? AND server_id = ? AND permission = ?`,
+		user.Id, serverId, string(permission)).Scan(&count)
+	return count > 0, err
+}
+
+// VisibleServers returns the ids of the servers a member may view. all is true for
+// admins, who see every server.
+func VisibleServers(user *User) (ids map[string]bool, all bool, err error) {
+	if user.Role == RoleAdmin {
+		return nil, true, nil
+	}
+
+	rows, err := database.Get().Client.Query(`
+		SELECT server_id FROM server_grants WHERE user_id = ? AND permission = ?`, user.Id, string(PermView))
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	ids = make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, false, err
+		}
+		ids[id] = true
+	}
+	return ids, false, rows.Err()
+}
+
+// GetGrants returns the grants of every user on a server
+func GetGrants(serverId string) ([]Grant, error) {
+	rows, err := database.Get().Client.Query(`
+		SELECT g.user_id, u.username, g.permission FROM server_grants g JOIN users u ON u.id = g.user_id
+		WHERE g.server_id = ? ORDER BY u.username`, serverId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byUser := make(map[int64]*Grant)
+	var order []int64
+	for rows.Next() {
+		var userId int64
+		var username, permission string
+		if err := rows.Scan(&userId, &username, &permission); err != nil {
+			return nil, err
+		}
+		g, ok := byUser[userId]
+		if !ok {
+			g = &Grant{UserId: userId, Username: username, ServerId: serverId}
+			byUser[userId] = g
+			order = append(order, userId)
+		}
+		g.Permissions = append(g.Permissions, Permission(permission))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	grants := make([]Grant, 0, len(order))
+	for _, userId := range order {
+		g := byUser[userId]
+		sort.Slice(g.Permissions, func(i, j int) bool {
+			return permissionIndex(g.Permissions[i]) < permissionIndex(g.Permissions[j])
+		})
+		grants = append(grants, *g)
+	}
+	return grants, nil
+}
+
+// GetUserGrants returns the permissions of a user on every server they were granted
+func GetUserGrants(userId int64) (map[string][]Permission, error) {
+	rows, err := database.Get().Client.Query(`
+		SELECT server_id, permission FROM server_grants WHERE user_id = ?`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := make(map[string][]Permission)
+	for rows.Next() {
+		var serverId, permission string
+		if err := rows.Scan(&serverId, &permission); err != nil {
+			return nil, err
+		}
+		grants[serverId] = append(grants[serverId], Permission(permission))
+	}
+	return grants, rows.Err()
+}
+
+// SetGrant replaces the permissions of a user on a server
+func SetGrant(userId int64, serverId string, permissions []Permission) error {
+	tx, err := database.Get().Client.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM server_grants WHERE user_id = ? AND server_id = ?`, userId, serverId); err != nil {
+		return err
+	}
+	for _, p := range permissions {
+		if _, err := tx.Exec(`INSERT INTO server_grants (user_id, server_id, permission) VALUES (?, ?, ?)`,
+			userId, serverId, string(p)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteGrant removes every permission of a user on a server
+func DeleteGrant(userId int64, serverId string) error {
+	_, err := database.Get().Client.Exec(`DELETE FROM server_grants WHERE user_id = ? AND server_id = ?`, userId, serverId)
+	return err
+}
+
+// DeleteServerGrants removes the grants on a deleted server
+func DeleteServerGrants(serverId string) error {
+	_, err := database.Get().Client.Exec(`DELETE FROM server_grants WHERE server_id = ?`, serverId)
+	return err
+}
+
+func permissionIndex(p Permission) int {
+	for i, known := range AllPermissions {
+		if p == known {
+			return i
+		}
+	}
+	return len(AllPermissions)
+}
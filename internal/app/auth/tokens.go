@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"time"
+	"watercolormc/internal/database"
+)
+
+const (
+	// tokens are prefixed by kind so a lookup only has to check one table
+	sessionPrefix  = "wcs_"
+	apiTokenPrefix = "wct_"
+
+	SessionDuration = 7 * 24 * time.Hour
+
+	// LocalsIdentity is the fiber locals key the authenticated Identity is stored under
+	LocalsIdentity = "identity"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Identity is who a request was authenticated as. Exactly one of SessionToken and
+// TokenId is set, depending on whether a session or an API token was presented.
+type Identity struct {
+	User         *User
+	SessionToken string
+	TokenId      int64
+}
+
+// Current returns the identity of an authenticated request
+func Current(c *fiber.Ctx) *Identity {
+	identity, _ := c.Locals(LocalsIdentity).(*Identity)
+	return identity
+}
+
+type Session struct {
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expiresAt"`
+	User      *User  `json:"user"`
+}
+
+type ApiToken struct {
+	Id         int64   `json:"id"`
+	Name       string  `json:"name"`
+	Token      string  `json:"token,omitempty"`
+	CreatedAt  string  `json:"createdAt"`
+	LastUsedAt *string `json:"lastUsedAt"`
+}
+
+// only hashes are stored, so a leaked database does not leak usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a session for a user who logged in
+func CreateSession(user *User, clientAddr string, userAgent string) (*Session, error) {
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	token := sessionPrefix + secret
+	expires := time.Now().Add(SessionDuration)
+
+	db := database.Get()
+	if _, err := db.Client.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+	_, err = db.Client.Exec(`
+		INSERT INTO sessions (token_hash, user_id, client_addr, user_agent, expires_at)
+		VALUES (?, ?, ?, ?, ?)`, hashToken(token), user.Id, clientAddr, userAgent, expires.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{Token: token, ExpiresAt: expires.Format(time.RFC3339), User: user}, nil
+}
+
+// DeleteSession logs a session out
+func DeleteSession(token string) error {
+	_, err := database.Get().Client.Exec(`DELETE FROM sessions WHERE token_hash = ?`, hashToken(token))
+	return err
+}
+
+// CreateApiToken issues a long-lived token for scripts. The token itself is only
+// returned here and cannot be looked up again.
+func CreateApiToken(user *User, name string) (*ApiToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("token name is required")
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	token := apiTokenPrefix + secret
+
+	db := database.Get()
+	result, err := db.Client.Exec(`INSERT INTO api_tokens (user_id, name, token_hash) VALUES (?, ?, ?)`,
+		user.Id, name, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	t := ApiToken{Id: id, Name: name, Token: token}
+	if err := db.Client.QueryRow(`SELECT created_at FROM api_tokens WHERE id = ?`, id).Scan(&t.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListApiTokens returns the tokens of a user without their secrets
+func ListApiTokens(user *User) ([]ApiToken, error) {
+	rows, err := database.Get().Client.Query(`
+		SELECT id, name, created_at, last_used_at FROM api_tokens WHERE user_id = ? ORDER BY id`, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []ApiToken{}
+	for rows.Next() {
+		var t ApiToken
+		if err := rows.Scan(&t.Id, &t.Name, &t.CreatedAt, &t.LastUsedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteApiToken revokes one of a user's tokens
+func DeleteApiToken(user *User, id int64) error {
+	result, err := database.Get().Client.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, user.Id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("token not found")
+	}
+	return nil
+}
+
+// Resolve looks up the user a session or API token belongs to
+func Resolve(token string) (*Identity, error) {
+	db := database.Get()
+	if db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	var u User
+	var err error
+	identity := &Identity{User: &u}
+
+	switch {
+	case strings.HasPrefix(token, sessionPrefix):
+		err = scanUser(db.Client.QueryRow(`
+			SELECT `+userColumns+` FROM sessions s JOIN users u ON u.id = s.user_id
+			WHERE s.token_hash = ? AND s.expires_at > ?`, hashToken(token), time.Now().Unix()), &u)
+		identity.SessionToken = token
+	case strings.HasPrefix(token, apiTokenPrefix):
+		err = scanUser(db.Client.QueryRow(`
+			SELECT `+userColumns+`, t.id FROM api_tokens t JOIN users u ON u.id = t.user_id
+			WHERE t.token_hash = ?`, hashToken(token)), &u, &identity.TokenId)
+		if err == nil {
+			_, err = db.Client.Exec(`UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, identity.TokenId)
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
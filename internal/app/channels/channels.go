@@ -1,19 +1,130 @@
 package channels
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
+	"watercolormc/internal/app/auth"
+	"watercolormc/internal/metrics"
 )
 
+// ProtocolVersion is the version of the envelope format
+const ProtocolVersion = 1
+
+// formats a connection can ask for with the format query parameter
+const (
+	FormatRaw      = "raw"
+	FormatEnvelope = "envelope"
+)
+
+// message types
+const (
+	TypeConsoleLine   = "console.line"
+	TypeServerStats   = "server.stats"
+	TypeServerUpdated = "server.updated"
+	TypePlayerJoin    = "player.join"
+	TypePlayerLeave   = "player.leave"
+	TypeConsoleEvent  = "console.event"
+	TypeClientMessage = "client.message"
+)
+
+// Client describes the connection a message was received from
+type Client struct {
+	Addr      string
+	UserAgent string
+	Username  string
+}
+
+// Message is something published on a channel. Envelope clients receive Data
+// wrapped in an Envelope, raw clients receive Raw, or Data as JSON if Raw is nil.
+type Message struct {
+	Type   string
+	Server string
+	Data   interface{}
+	Raw    []byte
+}
+
+// Envelope is the frame sent to clients using the envelope format. Seq counts the
+// messages published on the channel so clients can detect gaps; it is omitted on
+// messages replayed from history.
+type Envelope struct {
+	V       int         `json:"v"`
+	Type    string      `json:"type"`
+	Channel string      `json:"channel"`
+	Server  string      `json:"server,omitempty"`
+	Seq     uint64      `json:"seq,omitempty"`
+	Ts      int64       `json:"ts"`
+	Replay  bool        `json:"replay,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+type subscriber struct {
+	conn     *websocket.Conn
+	envelope bool
+	client   Client
+
+	// patterns holds the wildcard topics of a multiplexed connection
+	patterns map[string]bool
+
+	// user is who the connection authenticated as, allowed caches the channels the
+	// user was checked against
+	user      *auth.User
+	allowedMu sync.Mutex
+	allowed   map[string]bool
+
+	// outbound frames, written by the subscriber's own goroutine
+	queueMu sync.Mutex
+	queue   []frame
+	dropped int
+	notify  chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
 var (
 	channelsMu       sync.RWMutex
-	channels         = make(map[string]map[*websocket.Conn]bool)
-	channelListeners = make(map[string]func(string) error)
+	channels         = make(map[string]map[*websocket.Conn]*subscriber)
+	patternSubs      = make(map[*websocket.Conn]*subscriber)
+	channelStates    = make(map[string]*channelState)
+	channelListeners = make(map[string]func(string, Client) error)
+	channelReplayers = make(map[string]func() []Message)
+	authorizer       func(user *auth.User, channel string) bool
 )
 
+// SetAuthorizer assigns the function deciding which channels a user may subscribe
+// and publish to. Without one, every authenticated user may use every channel.
+func SetAuthorizer(fn func(user *auth.User, channel string) bool) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	authorizer = fn
+}
+
+// may reports whether the subscriber's user may use a channel. Results are cached
+// for the lifetime of the connection, DisconnectUser ends it when they go stale.
+// The authorizer may query the database, so may must not be called while holding
+// channelsMu.
+func (s *subscriber) may(channel string) bool {
+	if authorizer == nil || s.user == nil {
+		return authorizer == nil
+	}
+
+	s.allowedMu.Lock()
+	defer s.allowedMu.Unlock()
+
+	if ok, cached := s.allowed[channel]; cached {
+		return ok
+	}
+	ok := authorizer(s.user, channel)
+	s.allowed[channel] = ok
+	return ok
+}
+
 // Init initializes the websocket route and handles connections
 func Init(app *fiber.App) {
 	app.Get("/channels/:channel", websocket.New(func(c *websocket.Conn) {
@@ -23,11 +134,22 @@ func Init(app *fiber.App) {
 			return
 		}
 
+		sub := newSubscriber(c, c.Query("format", FormatRaw) == FormatEnvelope)
+		if !sub.may(channel) {
+			_ = c.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "forbidden"), time.Now().Add(writeTimeout))
+			sub.close()
+			_ = c.Close()
+			return
+		}
+
 		channelsMu.Lock()
 		if channels[channel] == nil {
-			channels[channel] = make(map[*websocket.Conn]bool)
+			channels[channel] = make(map[*websocket.Conn]*subscriber)
 		}
-		channels[channel][c] = true
+		channels[channel][c] = sub
+		// replay while holding the lock so no broadcast is interleaved with the history
+		replay(channel, sub)
 		channelsMu.Unlock()
 
 		defer func() {
@@ -36,63 +158,245 @@ func Init(app *fiber.App) {
 			if len(channels[channel]) == 0 {
 				delete(channels, channel)
 			}
+			forgetIdle(channel)
 			channelsMu.Unlock()
+			sub.close()
 			_ = c.Close()
 		}()
 
 		for {
-			msgType, msg, err := c.ReadMessage()
+			msg, err := sub.read()
 			if err != nil {
 				break
 			}
 
-			channelsMu.RLock()
-			listener, hasListener := channelListeners[channel]
-			channelsMu.RUnlock()
-
-			if hasListener {
-				go func(m []byte) {
-					if err := listener(string(m)); err != nil {
-						fmt.Printf("channel listener error: %v\n", err)
-					}
-				}(msg)
-				continue
-			}
+			receive(channel, msg, sub)
+		}
+	}))
+
+	initMux(app)
+}
+
+// receive hands a message sent by a client to the channel's listener, or relays it
+// to the other subscribers if the channel has none
+func receive(channel string, msg []byte, sub *subscriber) {
+	channelsMu.RLock()
+	listener, hasListener := channelListeners[channel]
+	channelsMu.RUnlock()
 
-			channelsMu.RLock()
-			for conn := range channels[channel] {
-				if conn != c {
-					_ = conn.WriteMessage(msgType, msg)
-				}
+	if hasListener {
+		go func() {
+			if err := listener(string(msg), sub.client); err != nil {
+				fmt.Printf("channel listener error: %v\n", err)
 			}
-			channelsMu.RUnlock()
+		}()
+		return
+	}
+
+	_ = broadcast(channel, Message{Type: TypeClientMessage, Data: string(msg), Raw: msg}, sub.conn)
+}
+
+// replay sends the history of a channel to a new subscriber. The caller must hold
+// channelsMu.
+func replay(channel string, sub *subscriber) {
+	replayer, ok := channelReplayers[channel]
+	if !ok {
+		return
+	}
+
+	var frames [][]byte
+	for _, msg := range replayer() {
+		frame, err := encode(channel, msg, 0, sub.envelope)
+		if err != nil {
+			continue
 		}
-	}))
+		frames = append(frames, frame)
+	}
+	sub.sendAll(frames...)
 }
 
-// BroadcastToChannel sends a message to all connections in a channel
-func BroadcastToChannel(channel string, msgType int, msg []byte) error {
+// BroadcastToChannel queues a message for all connections in a channel, each in the
+// format it asked for. It never blocks on a slow connection.
+func BroadcastToChannel(channel string, msg Message) error {
+	return broadcast(channel, msg, nil)
+}
+
+// channelState is what a channel keeps between broadcasts. It exists while anyone
+// uses the channel and is dropped by forgetIdle once nobody does.
+type channelState struct {
+	// mu serializes the broadcasts on the channel
+	mu  sync.Mutex
+	seq atomic.Uint64
+	// broadcasts counts the broadcasts holding the state
+	broadcasts int
+}
+
+// acquireChannel returns the state of a channel for a broadcast, which must hand
+// it back with releaseChannel
+func acquireChannel(channel string) *channelState {
 	channelsMu.Lock()
-	if channels[channel] == nil {
-		channels[channel] = make(map[*websocket.Conn]bool)
+	defer channelsMu.Unlock()
+
+	state, ok := channelStates[channel]
+	if !ok {
+		state = &channelState{}
+		channelStates[channel] = state
 	}
-	conns := channels[channel]
-	channelsMu.Unlock()
+	state.broadcasts++
+	return state
+}
+
+func releaseChannel(channel string, state *channelState) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+
+	state.broadcasts--
+	forgetIdle(channel)
+}
+
+// forgetIdle drops the state of a channel that has no broadcast in flight, no
+// subscribers, listener or replayer, so channels of deleted servers and one-off
+// topics do not pile up. The caller must hold channelsMu.
+func forgetIdle(channel string) {
+	state, ok := channelStates[channel]
+	if !ok || state.broadcasts > 0 || len(channels[channel]) > 0 {
+		return
+	}
+	if _, ok := channelListeners[channel]; ok {
+		return
+	}
+	if _, ok := channelReplayers[channel]; ok {
+		return
+	}
+	for _, sub := range patternSubs {
+		if sub.matches(channel) {
+			return
+		}
+	}
+	delete(channelStates, channel)
+}
+
+// forgetAllIdle runs forgetIdle on every channel, for when a wildcard subscription
+// goes away. The caller must hold channelsMu.
+func forgetAllIdle() {
+	for channel := range channelStates {
+		forgetIdle(channel)
+	}
+}
+
+// broadcast assigns the message the channel's next seq and queues it. Both happen
+// under the channel's lock, so every subscriber sees seqs in order.
+func broadcast(channel string, msg Message, except *websocket.Conn) error {
+	state := acquireChannel(channel)
+	defer releaseChannel(channel, state)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	seq := state.seq.Add(1)
+
+	var raw, envelope []byte
 
 	channelsMu.RLock()
-	defer channelsMu.RUnlock()
+	recipients := make([]*subscriber, 0, len(channels[channel]))
+	for _, sub := range channels[channel] {
+		recipients = append(recipients, sub)
+	}
+	var candidates []*subscriber
+	for conn, sub := range patternSubs {
+		if _, exact := channels[channel][conn]; !exact && sub.matches(channel) {
+			candidates = append(candidates, sub)
+		}
+	}
+	channelsMu.RUnlock()
 
-	for conn := range conns {
-		if err := conn.WriteMessage(msgType, msg); err != nil {
-			return fmt.Errorf("failed to send message to channel %s: %w", channel, err)
+	// exact subscribers were checked when subscribing, wildcard ones are checked
+	// per channel
+	for _, sub := range candidates {
+		if sub.may(channel) {
+			recipients = append(recipients, sub)
 		}
 	}
 
+	for _, sub := range recipients {
+		if sub.conn == except {
+			continue
+		}
+
+		var err error
+		frame := raw
+		if sub.envelope {
+			frame = envelope
+		}
+		if frame == nil {
+			frame, err = encode(channel, msg, seq, sub.envelope)
+			if err != nil {
+				return fmt.Errorf("failed to encode message for channel %s: %w", channel, err)
+			}
+			if sub.envelope {
+				envelope = frame
+			} else {
+				raw = frame
+			}
+		}
+
+		sub.send(channel, frame)
+	}
+
 	return nil
 }
 
+// encode renders a message in the envelope or raw format. A zero seq marks a
+// replayed message.
+func encode(channel string, msg Message, seq uint64, envelope bool) ([]byte, error) {
+	if !envelope {
+		if msg.Raw != nil {
+			return msg.Raw, nil
+		}
+		return json.Marshal(msg.Data)
+	}
+
+	return json.Marshal(Envelope{
+		V:       ProtocolVersion,
+		Type:    msg.Type,
+		Channel: channel,
+		Server:  msg.Server,
+		Seq:     seq,
+		Ts:      time.Now().UnixMilli(),
+		Replay:  seq == 0,
+		Data:    msg.Data,
+	})
+}
+
+// DisconnectUser closes every connection of a user, so a change to their role or
+// grants takes effect. Clients reconnect and are authorized afresh.
+func DisconnectUser(userId int64) {
+	channelsMu.RLock()
+	subs := make(map[*websocket.Conn]*subscriber)
+	for _, conns := range channels {
+		for conn, sub := range conns {
+			if sub.user != nil && sub.user.Id == userId {
+				subs[conn] = sub
+			}
+		}
+	}
+	for conn, sub := range patternSubs {
+		if sub.user != nil && sub.user.Id == userId {
+			subs[conn] = sub
+		}
+	}
+	channelsMu.RUnlock()
+
+	// the handlers notice the closed connection and unregister it
+	for conn := range subs {
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "permissions changed"), time.Now().Add(writeTimeout))
+		_ = conn.Close()
+	}
+}
+
 // SetListener assigns a listener function for a channel
-func SetListener(channel string, listener func(string) error) {
+func SetListener(channel string, listener func(string, Client) error) {
 	channelsMu.Lock()
 	defer channelsMu.Unlock()
 	channelListeners[channel] = listener
@@ -103,6 +407,117 @@ func RemoveListener(channel string) {
 	channelsMu.Lock()
 	defer channelsMu.Unlock()
 	delete(channelListeners, channel)
+	forgetIdle(channel)
+}
+
+// SetReplayer assigns a function returning the messages a newly connected client
+// receives before any live traffic on the channel
+func SetReplayer(channel string, replayer func() []Message) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	channelReplayers[channel] = replayer
+}
+
+// RemoveReplayer removes the replayer for a channel
+func RemoveReplayer(channel string) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	delete(channelReplayers, channel)
+	forgetIdle(channel)
+}
+
+// ChannelInfo describes a channel for diagnostics
+type ChannelInfo struct {
+	Name               string `json:"name"`
+	Subscribers        int    `json:"subscribers"`
+	PatternSubscribers int    `json:"patternSubscribers"`
+	Published          uint64 `json:"published"`
+	HasListener        bool   `json:"hasListener"`
+	HasReplayer        bool   `json:"hasReplayer"`
+}
+
+// PatternInfo describes a wildcard subscription of multiplexed connections
+type PatternInfo struct {
+	Pattern     string `json:"pattern"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// Overview lists every known channel, whether it has subscribers, a listener or a
+// replayer, along with the wildcard subscriptions
+type Overview struct {
+	Connections int           `json:"connections"`
+	Channels    []ChannelInfo `json:"channels"`
+	Patterns    []PatternInfo `json:"patterns"`
+}
+
+// List returns an overview of the channel registry
+func List() Overview {
+	channelsMu.RLock()
+	defer channelsMu.RUnlock()
+
+	names := make(map[string]bool)
+	for name := range channels {
+		names[name] = true
+	}
+	for name := range channelListeners {
+		names[name] = true
+	}
+	for name := range channelReplayers {
+		names[name] = true
+	}
+
+	conns := make(map[*websocket.Conn]bool)
+	overview := Overview{Channels: []ChannelInfo{}, Patterns: []PatternInfo{}}
+	for name := range names {
+		info := ChannelInfo{Name: name, Subscribers: len(channels[name])}
+		if state, ok := channelStates[name]; ok {
+			info.Published = state.seq.Load()
+		}
+		_, info.HasListener = channelListeners[name]
+		_, info.HasReplayer = channelReplayers[name]
+		for conn := range channels[name] {
+			conns[conn] = true
+		}
+		for conn, sub := range patternSubs {
+			if _, exact := channels[name][conn]; !exact && sub.matches(name) {
+				info.PatternSubscribers++
+			}
+		}
+		overview.Channels = append(overview.Channels, info)
+	}
+	sort.Slice(overview.Channels, func(i, j int) bool {
+		return overview.Channels[i].Name < overview.Channels[j].Name
+	})
+
+	patterns := make(map[string]int)
+	for conn, sub := range patternSubs {
+		conns[conn] = true
+		for pattern := range sub.patterns {
+			patterns[pattern]++
+		}
+	}
+	for pattern, count := range patterns {
+		overview.Patterns = append(overview.Patterns, PatternInfo{Pattern: pattern, Subscribers: count})
+	}
+	sort.Slice(overview.Patterns, func(i, j int) bool {
+		return overview.Patterns[i].Pattern < overview.Patterns[j].Pattern
+	})
+
+	overview.Connections = len(conns)
+	return overview
+}
+
+// CollectMetrics reports the number of open connections per channel
+func CollectMetrics(e *metrics.Encoder) {
+	channelsMu.RLock()
+	defer channelsMu.RUnlock()
+
+	e.Header("watercolormc_websocket_connections", "gauge", "Open websocket connections per channel.")
+	for channel, conns := range channels {
+		e.Sample("watercolormc_websocket_connections", float64(len(conns)), "channel", channel)
+	}
+
+	collectQueueMetrics(e)
 }
 
 // Cleanup closes and removes dead connections and cleans empty channels
@@ -111,8 +526,8 @@ func Cleanup() {
 	defer channelsMu.Unlock()
 
 	for channel, conns := range channels {
-		for conn := range conns {
-			if err := conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
+		for conn, sub := range conns {
+			if err := sub.ping(); err != nil {
 				_ = conn.Close()
 				delete(conns, conn)
 			}
@@ -121,4 +536,13 @@ func Cleanup() {
 			delete(channels, channel)
 		}
 	}
-}
\ No newline at end of file
+
+	for conn, sub := range patternSubs {
+		if err := sub.ping(); err != nil {
+			_ = conn.Close()
+			delete(patternSubs, conn)
+		}
+	}
+
+	forgetAllIdle()
+}
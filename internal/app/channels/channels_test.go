@@ -0,0 +1,40 @@
+package channels
+
+import "testing"
+
+func published(channel string) (uint64, bool) {
+	channelsMu.RLock()
+	defer channelsMu.RUnlock()
+
+	state, ok := channelStates[channel]
+	if !ok {
+		return 0, false
+	}
+	return state.seq.Load(), true
+}
+
+func TestChannelStateIsForgottenWhenIdle(t *testing.T) {
+	const channel = "server:stdin:test"
+
+	if err := BroadcastToChannel(channel, Message{Data: "nobody listens"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := published(channel); ok {
+		t.Fatal("a channel nobody uses kept its state")
+	}
+
+	SetListener(channel, func(string, Client) error { return nil })
+	for i := 0; i < 3; i++ {
+		if err := BroadcastToChannel(channel, Message{Data: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seq, ok := published(channel); !ok || seq != 3 {
+		t.Fatalf("got seq %d (kept %v), want 3", seq, ok)
+	}
+
+	RemoveListener(channel)
+	if _, ok := published(channel); ok {
+		t.Fatal("state was kept after the channel was torn down")
+	}
+}
@@ -0,0 +1,209 @@
+package channels
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// operations of the multiplexed protocol
+const (
+	OpSubscribe   = "subscribe"
+	OpUnsubscribe = "unsubscribe"
+	OpPublish     = "publish"
+	OpAck         = "ack"
+)
+
+// Request is a frame sent by a client over /ws. Topics are channel names and may
+// contain wildcards, e.g. server:stats:*, except when publishing.
+type Request struct {
+	Op    string `json:"op"`
+	Id    string `json:"id,omitempty"`
+	Topic string `json:"topic"`
+	Data  string `json:"data,omitempty"`
+}
+
+// Ack answers a request. Every message published on a subscribed topic arrives as
+// an Envelope.
+type Ack struct {
+	Op    string `json:"op"`
+	Id    string `json:"id,omitempty"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+var errForbidden = errors.New("forbidden")
+
+func isPattern(topic string) bool {
+	return strings.ContainsAny(topic, `*?[\`)
+}
+
+func (s *subscriber) matches(channel string) bool {
+	for pattern := range s.patterns {
+		if ok, _ := path.Match(pattern, channel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func initMux(app *fiber.App) {
+	app.Get("/ws", websocket.New(func(c *websocket.Conn) {
+		sub := newSubscriber(c, true)
+		sub.patterns = make(map[string]bool)
+
+		defer func() {
+			channelsMu.Lock()
+			for channel, conns := range channels {
+				if conns[c] == sub {
+					delete(conns, c)
+					if len(conns) == 0 {
+						delete(channels, channel)
+					}
+				}
+			}
+			delete(patternSubs, c)
+			forgetAllIdle()
+			channelsMu.Unlock()
+			sub.close()
+			_ = c.Close()
+		}()
+
+		for {
+			frame, err := sub.read()
+			if err != nil {
+				break
+			}
+
+			var req Request
+			if err := json.Unmarshal(frame, &req); err != nil {
+				sub.ack(Request{}, errors.New("invalid request"))
+				continue
+			}
+
+			switch req.Op {
+			case OpSubscribe:
+				sub.ack(req, subscribe(sub, req.Topic))
+			case OpUnsubscribe:
+				sub.ack(req, unsubscribe(sub, req.Topic))
+			case OpPublish:
+				if req.Topic == "" || isPattern(req.Topic) {
+					sub.ack(req, errors.New("cannot publish to a wildcard topic"))
+					continue
+				}
+				if !sub.may(req.Topic) {
+					sub.ack(req, errForbidden)
+					continue
+				}
+				receive(req.Topic, []byte(req.Data), sub)
+				sub.ack(req, nil)
+			default:
+				sub.ack(req, errors.New("unknown op "+req.Op))
+			}
+		}
+	}))
+}
+
+func (s *subscriber) ack(req Request, err error) {
+	ack := Ack{Op: OpAck, Id: req.Id, Ok: err == nil}
+	if err != nil {
+		ack.Error = err.Error()
+	}
+
+	frame, marshalErr := json.Marshal(ack)
+	if marshalErr != nil {
+		return
+	}
+	s.sendAll(frame)
+}
+
+func subscribe(sub *subscriber, topic string) error {
+	if topic == "" {
+		return errors.New("missing topic")
+	}
+	if _, err := path.Match(topic, ""); err != nil {
+		return errors.New("invalid topic pattern")
+	}
+
+	if !isPattern(topic) {
+		if !sub.may(topic) {
+			return errForbidden
+		}
+
+		channelsMu.Lock()
+		defer channelsMu.Unlock()
+
+		if channels[topic] == nil {
+			channels[topic] = make(map[*websocket.Conn]*subscriber)
+		}
+		if _, ok := channels[topic][sub.conn]; !ok {
+			channels[topic][sub.conn] = sub
+			replay(topic, sub)
+		}
+		return nil
+	}
+
+	// find the histories to replay first, authorizing them needs the lock released
+	channelsMu.RLock()
+	var matched []string
+	for channel := range channelReplayers {
+		if ok, _ := path.Match(topic, channel); ok {
+			matched = append(matched, channel)
+		}
+	}
+	channelsMu.RUnlock()
+
+	var allowed []string
+	for _, channel := range matched {
+		if sub.may(channel) {
+			allowed = append(allowed, channel)
+		}
+	}
+
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+
+	if sub.patterns[topic] {
+		return nil
+	}
+	sub.patterns[topic] = true
+	patternSubs[sub.conn] = sub
+
+	for _, channel := range allowed {
+		if _, ok := channelReplayers[channel]; ok {
+			replay(channel, sub)
+		}
+	}
+	return nil
+}
+
+func unsubscribe(sub *subscriber, topic string) error {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+
+	if !isPattern(topic) {
+		if _, ok := channels[topic][sub.conn]; !ok {
+			return errors.New("not subscribed to " + topic)
+		}
+		delete(channels[topic], sub.conn)
+		if len(channels[topic]) == 0 {
+			delete(channels, topic)
+		}
+		forgetIdle(topic)
+		return nil
+	}
+
+	if !sub.patterns[topic] {
+		return errors.New("not subscribed to " + topic)
+	}
+	delete(sub.patterns, topic)
+	if len(sub.patterns) == 0 {
+		delete(patternSubs, sub.conn)
+	}
+	forgetAllIdle()
+	return nil
+}
@@ -0,0 +1,202 @@
+package channels
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"watercolormc/internal/app/auth"
+	"watercolormc/internal/metrics"
+)
+
+const (
+	// sendQueueSize is the number of frames a connection may have waiting to be
+	// written before further messages are dropped
+	sendQueueSize = 512
+	// maxDropped is the number of messages a connection may miss in a row before it
+	// is treated as stuck and disconnected
+	maxDropped = 2 * sendQueueSize
+	// writeTimeout bounds a single write so a client that stops reading is dropped
+	writeTimeout = 10 * time.Second
+	// pongWait is how long a connection may stay silent before it is considered dead;
+	// pings are sent often enough that a live client always answers in time
+	pongWait     = 60 * time.Second
+	pingInterval = pongWait * 9 / 10
+)
+
+var (
+	droppedMu       sync.Mutex
+	droppedMessages = make(map[string]uint64)
+	slowDisconnects uint64
+	deadDisconnects uint64
+)
+
+type frame struct {
+	msgType int
+	data    []byte
+}
+
+// newSubscriber wraps a connection and starts the goroutine that writes its queue.
+// close must be called before the websocket handler returns.
+func newSubscriber(c *websocket.Conn, envelope bool) *subscriber {
+	sub := &subscriber{
+		conn:     c,
+		envelope: envelope,
+		client:   Client{Addr: c.RemoteAddr().String(), UserAgent: c.Headers("User-Agent")},
+		allowed:  make(map[string]bool),
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if identity, ok := c.Locals(auth.LocalsIdentity).(*auth.Identity); ok {
+		sub.user = identity.User
+		sub.client.Username = identity.User.Username
+	}
+
+	// every pong, or any other frame read, pushes the read deadline back
+	_ = c.SetReadDeadline(time.Now().Add(pongWait))
+	c.SetPongHandler(func(string) error {
+		return c.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	go sub.writeLoop()
+	return sub
+}
+
+// read returns the next message of the connection and extends its read deadline.
+// It fails once the client has not answered a ping within pongWait.
+func (s *subscriber) read() ([]byte, error) {
+	_, msg, err := s.conn.ReadMessage()
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			droppedMu.Lock()
+			deadDisconnects++
+			droppedMu.Unlock()
+		}
+		return nil, err
+	}
+	_ = s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	return msg, nil
+}
+
+// send queues a frame published on channel. The frame is dropped if the queue is
+// full, and the connection is closed once too many frames were dropped in a row.
+func (s *subscriber) send(channel string, data []byte) {
+	s.queueMu.Lock()
+	if len(s.queue) >= sendQueueSize {
+		s.dropped++
+		disconnect := s.dropped == maxDropped
+		s.queueMu.Unlock()
+
+		droppedMu.Lock()
+		droppedMessages[channel]++
+		if disconnect {
+			slowDisconnects++
+		}
+		droppedMu.Unlock()
+
+		if disconnect {
+			// unblocks the reader, whose handler then removes the subscriber
+			_ = s.conn.Close()
+		}
+		return
+	}
+	s.dropped = 0
+	s.queue = append(s.queue, frame{msgType: websocket.TextMessage, data: data})
+	s.queueMu.Unlock()
+
+	s.wake()
+}
+
+// sendAll queues frames regardless of the queue limit. It is used for replayed
+// history and replies to requests, which a client must not miss.
+func (s *subscriber) sendAll(frames ...[]byte) {
+	s.queueMu.Lock()
+	for _, data := range frames {
+		s.queue = append(s.queue, frame{msgType: websocket.TextMessage, data: data})
+	}
+	s.queueMu.Unlock()
+
+	s.wake()
+}
+
+func (s *subscriber) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) writeLoop() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.ping(); err != nil {
+				_ = s.conn.Close()
+				<-s.done
+				return
+			}
+			continue
+		case <-s.notify:
+		}
+
+		s.queueMu.Lock()
+		pending := s.queue
+		s.queue = nil
+		s.queueMu.Unlock()
+
+		for _, f := range pending {
+			_ = s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := s.conn.WriteMessage(f.msgType, f.data); err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					droppedMu.Lock()
+					slowDisconnects++
+					droppedMu.Unlock()
+				}
+				_ = s.conn.Close()
+				<-s.done
+				return
+			}
+		}
+	}
+}
+
+// close stops the writer and waits for it, so the connection is not used after the
+// handler has returned
+func (s *subscriber) close() {
+	close(s.done)
+	<-s.stopped
+}
+
+// ping sends a ping control frame. Control frames may be written concurrently with
+// the writer goroutine.
+func (s *subscriber) ping() error {
+	return s.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(writeTimeout))
+}
+
+func collectQueueMetrics(e *metrics.Encoder) {
+	droppedMu.Lock()
+	defer droppedMu.Unlock()
+
+	e.Header("watercolormc_websocket_dropped_messages_total", "counter", "Messages dropped because a connection's send queue was full.")
+	for channel, count := range droppedMessages {
+		e.Sample("watercolormc_websocket_dropped_messages_total", float64(count), "channel", channel)
+	}
+
+	e.Header("watercolormc_websocket_slow_disconnects_total", "counter", "Connections closed for not keeping up with their send queue.")
+	e.Sample("watercolormc_websocket_slow_disconnects_total", float64(slowDisconnects))
+
+	e.Header("watercolormc_websocket_dead_disconnects_total", "counter", "Connections closed for not answering pings.")
+	e.Sample("watercolormc_websocket_dead_disconnects_total", float64(deadDisconnects))
+}
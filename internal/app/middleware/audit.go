@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"watercolormc/internal/app/audit"
+	"watercolormc/internal/app/auth"
+)
+
+// bodies larger than this are not copied into the audit log
+const maxAuditedBody = 64 * 1024
+
+// auditActions names the mutating routes. Mutating routes missing here are still
+// recorded, under their method and path.
+var auditActions = map[string]string{
+	"POST /api/auth/login":                               "auth.login",
+	"POST /api/auth/logout":                              "auth.logout",
+	"POST /api/auth/password":                            "auth.password",
+	"POST /api/auth/tokens":                              "auth.token.create",
+	"DELETE /api/auth/tokens/:tokenId":                   "auth.token.delete",
+	"POST /api/users":                                    "user.create",
+	"PUT /api/users/:userId/role":                        "user.role",
+	"DELETE /api/users/:userId":                          "user.delete",
+	"PUT /api/servers/:id/grants/:userId":                "grant.set",
+	"DELETE /api/servers/:id/grants/:userId":             "grant.delete",
+	"POST /api/servers":                                  "server.create",
+	"PATCH /api/servers/:id":                             "server.update",
+	"DELETE /api/servers/:id":                            "server.delete",
+	"POST /api/servers/start/:id":                        "server.start",
+	"POST /api/servers/stop/:id":                         "server.stop",
+	"POST /api/servers/:id/kill":                         "server.kill",
+	"POST /api/servers/:id/command":                      "console.command",
+	"POST /api/servers/:id/world/upload":                 "world.upload",
+	"POST /api/servers/:id/properties":                   "properties.update",
+	"POST /api/servers/:id/config":                       "config.update",
+	"POST /api/servers/:id/backup":                       "backup.create",
+	"POST /api/servers/:id/restore":                      "backup.restore",
+	"DELETE /api/servers/:id/backups":                    "backup.delete",
+	"POST /api/servers/:id/plugins":                      "plugin.add",
+	"DELETE /api/servers/:id/plugins/:pluginName":        "plugin.remove",
+	"POST /api/servers/:id/plugins/manifest":             "plugin.manifest.add",
+	"DELETE /api/servers/:id/plugins/manifest/:pluginId": "plugin.manifest.remove",
+	"POST /api/settings":                                 "settings.update",
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// auditRequests records every mutating API call once its handler has finished
+func auditRequests(c *fiber.Ctx) error {
+	if !isMutating(c.Method()) || !strings.HasPrefix(normalizePath(c.Path()), "/api/") {
+		return c.Next()
+	}
+
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	message := ""
+	if fiberErr, ok := err.(*fiber.Error); ok {
+		status, message = fiberErr.Code, fiberErr.Message
+	} else if err != nil {
+		status, message = fiber.StatusInternalServerError, err.Error()
+	} else if status >= 400 {
+		message = string(c.Response().Body())
+	}
+	if len(message) > 500 {
+		message = message[:500]
+	}
+
+	route := c.Route().Path
+	action, ok := auditActions[c.Method()+" "+route]
+	if !ok {
+		action = c.Method() + " " + route
+	}
+
+	entry := audit.Entry{
+		ClientAddr: c.IP(),
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		ServerId:   c.Params("id"),
+		Action:     action,
+		Method:     c.Method(),
+		Path:       c.Path(),
+		Params:     auditParams(c),
+		Status:     status,
+		Result:     audit.ResultOk,
+		Error:      message,
+	}
+	if status >= 400 {
+		entry.Result = audit.ResultError
+	}
+
+	if identity := auth.Current(c); identity != nil {
+		entry.UserId = &identity.User.Id
+		entry.Username = identity.User.Username
+		if identity.TokenId != 0 {
+			entry.TokenId = &identity.TokenId
+		}
+	} else if username, ok := entry.Params["username"].(string); ok {
+		// logins have no identity yet, attribute them to the account tried
+		entry.Username = username
+	}
+	if action == "server.create" {
+		if id, ok := entry.Params["id"].(string); ok {
+			entry.ServerId = id
+		}
+	}
+
+	audit.Record(entry)
+	return err
+}
+
+// auditParams collects the route parameters, query and body of a request
+func auditParams(c *fiber.Ctx) map[string]interface{} {
+	params := make(map[string]interface{})
+
+	for _, name := range c.Route().Params {
+		if name != "id" {
+			params[name] = c.Params(name)
+		}
+	}
+
+	query := make(map[string]interface{})
+	c.Request().URI().QueryArgs().VisitAll(func(key []byte, value []byte) {
+		query[string(key)] = string(value)
+	})
+	if len(query) > 0 {
+		params["query"] = query
+	}
+
+	contentType := string(c.Request().Header.ContentType())
+	switch {
+	case strings.HasPrefix(contentType, fiber.MIMEMultipartForm):
+		// record what was uploaded, not the upload itself
+		if form, err := c.MultipartForm(); err == nil {
+			for name, values := range form.Value {
+				params[name] = strings.Join(values, ",")
+			}
+			for name, files := range form.File {
+				var names []string
+				for _, f := range files {
+					names = append(names, f.Filename)
+				}
+				params[name] = names
+			}
+		}
+	case len(c.Body()) > 0 && len(c.Body()) <= maxAuditedBody:
+		var body interface{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			break
+		}
+		if fields, ok := body.(map[string]interface{}); ok {
+			for key, value := range fields {
+				params[key] = value
+			}
+		} else {
+			params["body"] = body
+		}
+	}
+
+	return params
+}
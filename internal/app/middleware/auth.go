@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"strings"
+	"watercolormc/internal/app/auth"
+)
+
+// routes reachable without logging in, every other route requires it
+var publicRoutes = map[string]bool{
+	"/api/upstatus":   true,
+	"/api/auth/login": true,
+}
+
+// normalizePath brings a path into the form routes are matched in. Fiber matches
+// routes case-insensitively and ignores a trailing slash, so checks on the raw path
+// could be sidestepped with /API/servers.
+func normalizePath(path string) string {
+	path = strings.ToLower(path)
+	if len(path) > 1 {
+		path = strings.TrimRight(path, "/")
+	}
+	return path
+}
+
+func requiresAuth(path string) bool {
+	return !publicRoutes[normalizePath(path)]
+}
+
+func isWebsocketRoute(path string) bool {
+	path = normalizePath(path)
+	return strings.HasPrefix(path, "/channels/") || path == "/ws"
+}
+
+// authenticate rejects requests without a valid session or API token. Tokens are
+// sent as a bearer token; browsers cannot set headers on websocket upgrades, so
+// those may pass it in the token query parameter instead.
+func authenticate(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodOptions || !requiresAuth(c.Path()) {
+		return c.Next()
+	}
+
+	token := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if token == "" && isWebsocketRoute(c.Path()) {
+		token = c.Query("token")
+	}
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).SendString("authentication required")
+	}
+
+	identity, err := auth.Resolve(token)
+	if errors.Is(err, auth.ErrInvalidToken) {
+		return c.Status(fiber.StatusUnauthorized).SendString("invalid or expired token")
+	}
+	if err != nil {
+		zap.L().Error("error resolving token", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).SendString("error checking authentication")
+	}
+
+	c.Locals(auth.LocalsIdentity, identity)
+	return c.Next()
+}
@@ -3,12 +3,32 @@ package middleware
 import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"time"
+	"watercolormc/internal/metrics"
 )
 
 func Setup(app *fiber.App) {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "http://localhost:5173, tauri://localhost",
-		AllowMethods: "GET,POST,PUT,DELETE,OPTIONS",
+		AllowMethods: "GET,POST,PUT,PATCH,DELETE,OPTIONS",
 		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
 	}))
+
+	app.Use(func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if fiberErr, ok := err.(*fiber.Error); ok {
+			status = fiberErr.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		metrics.ObserveHTTPRequest(c.Method(), c.Route().Path, status, time.Since(start))
+
+		return err
+	})
+
+	app.Use(authenticate)
+	app.Use(auditRequests)
 }
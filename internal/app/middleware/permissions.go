@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"watercolormc/internal/app/auth"
+)
+
+// RequireAdmin only lets admins through
+func RequireAdmin(c *fiber.Ctx) error {
+	if auth.Current(c).User.Role != auth.RoleAdmin {
+		return c.Status(fiber.StatusForbidden).SendString("admin role required")
+	}
+	return c.Next()
+}
+
+// RequireServer only lets users through who hold permission on the server named by
+// the id route parameter
+func RequireServer(permission auth.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		ok, err := auth.Can(auth.Current(c).User, id, permission)
+		if err != nil {
+			zap.L().Error("error checking permission", zap.String("id", id), zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error checking permission")
+		}
+		if !ok {
+			return c.Status(fiber.StatusForbidden).SendString("missing permission: " + string(permission))
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+	"watercolormc/internal/app/audit"
+	"watercolormc/internal/app/middleware"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+func registerAuditRoutes(app *fiber.App) {
+	app.Get("/api/audit", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		filter, err := auditFilter(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		filter.Limit = c.QueryInt("limit", defaultAuditLimit)
+		if filter.Limit <= 0 || filter.Limit > maxAuditLimit {
+			filter.Limit = maxAuditLimit
+		}
+		if before := c.Query("before"); before != "" {
+			if filter.Before, err = strconv.ParseInt(before, 10, 64); err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("invalid before")
+			}
+		}
+
+		entries, err := audit.Query(filter)
+		if err != nil {
+			zap.L().Error("error querying audit log", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error querying audit log")
+		}
+
+		return c.JSON(entries)
+	})
+
+	app.Get("/api/audit/export", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		filter, err := auditFilter(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit-`+time.Now().Format("20060102-150405")+`.jsonl"`)
+
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			encoder := json.NewEncoder(w)
+			err := audit.Each(filter, func(e audit.Entry) error {
+				return encoder.Encode(e)
+			})
+			if err != nil {
+				zap.L().Error("error exporting audit log", zap.Error(err))
+			}
+			_ = w.Flush()
+		})
+		return nil
+	})
+}
+
+// auditFilter reads the user, server, action, result, from and to query parameters.
+// Times are RFC 3339.
+func auditFilter(c *fiber.Ctx) (audit.Filter, error) {
+	filter := audit.Filter{
+		Username: c.Query("user"),
+		ServerId: c.Query("server"),
+		Action:   c.Query("action"),
+		Result:   c.Query("result"),
+	}
+
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "invalid "+name+", expected RFC 3339")
+		}
+		*target = t
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"strconv"
+	"watercolormc/internal/app/auth"
+)
+
+func registerAuthRoutes(app *fiber.App) {
+	app.Post("/api/auth/login", func(c *fiber.Ctx) error {
+		var request struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			zap.L().Error("error parsing request body", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).SendString("invalid request body")
+		}
+
+		user, err := auth.Authenticate(request.Username, request.Password)
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			zap.L().Warn("failed login", zap.String("username", request.Username), zap.String("ip", c.IP()))
+			return c.Status(fiber.StatusUnauthorized).SendString("invalid username or password")
+		}
+		if err != nil {
+			zap.L().Error("error authenticating user", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error logging in")
+		}
+
+		session, err := auth.CreateSession(user, c.IP(), c.Get(fiber.HeaderUserAgent))
+		if err != nil {
+			zap.L().Error("error creating session", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error logging in")
+		}
+
+		zap.L().Info("user logged in", zap.String("username", user.Username), zap.String("ip", c.IP()))
+		return c.JSON(session)
+	})
+
+	app.Post("/api/auth/logout", func(c *fiber.Ctx) error {
+		identity := auth.Current(c)
+		if identity.SessionToken == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("not logged in with a session")
+		}
+
+		if err := auth.DeleteSession(identity.SessionToken); err != nil {
+			zap.L().Error("error deleting session", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error logging out")
+		}
+
+		return c.SendString("ok")
+	})
+
+	app.Get("/api/auth/me", func(c *fiber.Ctx) error {
+		user := auth.Current(c).User
+		grants, err := auth.GetUserGrants(user.Id)
+		if err != nil {
+			zap.L().Error("error loading grants", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error loading grants")
+		}
+
+		return c.JSON(map[string]interface{}{
+			"id":        user.Id,
+			"username":  user.Username,
+			"role":      user.Role,
+			"createdAt": user.CreatedAt,
+			"grants":    grants,
+		})
+	})
+
+	app.Post("/api/auth/password", func(c *fiber.Ctx) error {
+		var request struct {
+			Current  string `json:"current"`
+			Password string `json:"password"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			zap.L().Error("error parsing request body", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).SendString("invalid request body")
+		}
+
+		identity := auth.Current(c)
+		err := auth.ChangePassword(identity.User, request.Current, request.Password, identity.SessionToken)
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			return c.Status(fiber.StatusForbidden).SendString("current password is wrong")
+		}
+		if errors.Is(err, auth.ErrWeakPassword) {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		if err != nil {
+			zap.L().Error("error changing password", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error changing password")
+		}
+
+		return c.SendString("ok")
+	})
+
+	app.Get("/api/auth/tokens", func(c *fiber.Ctx) error {
+		tokens, err := auth.ListApiTokens(auth.Current(c).User)
+		if err != nil {
+			zap.L().Error("error listing api tokens", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error listing api tokens")
+		}
+
+		return c.JSON(tokens)
+	})
+
+	app.Post("/api/auth/tokens", func(c *fiber.Ctx) error {
+		var request struct {
+			Name string `json:"name"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			zap.L().Error("error parsing request body", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).SendString("invalid request body")
+		}
+		if request.Name == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("token name is required")
+		}
+
+		token, err := auth.CreateApiToken(auth.Current(c).User, request.Name)
+		if err != nil {
+			zap.L().Error("error creating api token", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error creating api token")
+		}
+
+		return c.JSON(token)
+	})
+
+	app.Delete("/api/auth/tokens/:tokenId", func(c *fiber.Ctx) error {
+		id, err := strconv.ParseInt(c.Params("tokenId"), 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("invalid token ID")
+		}
+
+		if err := auth.DeleteApiToken(auth.Current(c).User, id); err != nil {
+			return c.Status(fiber.StatusNotFound).SendString("token not found")
+		}
+
+		return c.SendString("ok")
+	})
+}
@@ -1,8 +1,10 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/magiconair/properties"
@@ -10,12 +12,16 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 	"watercolormc/internal"
+	"watercolormc/internal/app/auth"
+	"watercolormc/internal/app/channels"
+	"watercolormc/internal/app/middleware"
 	"watercolormc/internal/app/servers"
 	activeServers "watercolormc/internal/app/servers/active"
-	"watercolormc/internal/database"
 	"watercolormc/internal/paper/plugins"
 	"watercolormc/internal/utils"
 )
@@ -25,68 +31,56 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Get("/api/servers", func(c *fiber.Ctx) error {
-		db := database.Get()
+	registerAuthRoutes(app)
+	registerUserRoutes(app)
+	registerAuditRoutes(app)
 
-		rows, err := db.Client.Query(`
-		SELECT id, name, port, host, version, description, created_at
-		FROM servers
-	`)
+	app.Get("/api/servers", func(c *fiber.Ctx) error {
+		visible, all, err := auth.VisibleServers(auth.Current(c).User)
 		if err != nil {
+			zap.L().Error("error loading server grants", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).SendString("error querying servers")
 		}
-		defer rows.Close()
 
-		var servers []map[string]interface{}
-
-		for rows.Next() {
-			var (
-				id          string
-				name        string
-				port        int
-				host        string
-				version     string
-				description string
-				createdAt   time.Time
-			)
+		records, err := servers.Repository().List()
+		if err != nil {
+			zap.L().Error("error querying servers", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error querying servers")
+		}
 
-			if err := rows.Scan(&id, &name, &port, &host, &version, &description, &createdAt); err != nil {
-				zap.L().Error("error scanning server row", zap.Error(err))
-				return c.Status(fiber.StatusInternalServerError).SendString("error scanning server row")
+		list := []map[string]interface{}{}
+		for _, server := range records {
+			if !all && !visible[server.Id] {
+				continue
 			}
 
 			status := "offline"
-			if activeServers.IsOnline(id) {
+			state := activeServers.GetState(server.Id)
+			switch state {
+			case activeServers.StateRunning, activeServers.StateStopping:
 				status = "online"
+			case activeServers.StateInstalling, activeServers.StateStarting:
+				status = "starting"
 			}
 
-			servers = append(servers, map[string]interface{}{
-				"id":          id,
-				"name":        name,
-				"port":        port,
-				"host":        host,
-				"version":     version,
-				"description": description,
-				"createdAt":   createdAt.Format(time.RFC3339),
+			list = append(list, map[string]interface{}{
+				"id":          server.Id,
+				"name":        server.Name,
+				"port":        server.Port,
+				"host":        server.Host,
+				"version":     server.Version,
+				"description": server.Description,
+				"createdAt":   server.CreatedAt,
 				"status":      status,
+				"state":       string(state),
 			})
 		}
 
-		if err := rows.Err(); err != nil {
-			zap.L().Error("error iterating over server rows", zap.Error(err))
-			return c.Status(fiber.StatusInternalServerError).SendString("error iterating over server rows")
-		}
-
-		if servers == nil {
-			servers = []map[string]interface{}{}
-		}
-		zap.L().Info("retrieved servers", zap.Int("count", len(servers)))
-		return c.JSON(servers)
+		zap.L().Info("retrieved servers", zap.Int("count", len(list)))
+		return c.JSON(list)
 	})
 
-	app.Post("/api/servers", func(c *fiber.Ctx) error {
-		db := database.Get()
-
+	app.Post("/api/servers", middleware.RequireAdmin, func(c *fiber.Ctx) error {
 		var server servers.Server
 
 		if err := c.BodyParser(&server); err != nil {
@@ -112,43 +106,70 @@ func RegisterApiRoutes(app *fiber.App) {
 			return c.Status(fiber.StatusBadRequest).SendString("missing required fields")
 		}
 
-		_, err := db.Client.Exec(`
-		INSERT INTO servers (id, name, port, host, version, description)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, server.Id, server.Name, server.Port, server.Host, server.Version, server.Description)
+		if err := servers.CheckPort(server.Id, server.Host, server.Port); errors.Is(err, servers.ErrPortInUse) {
+			return sendPortConflict(c, err)
+		} else if err != nil {
+			zap.L().Error("error checking server port", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error checking server port")
+		}
 
+		created, err := servers.Repository().Create(server)
+		if errors.Is(err, servers.ErrServerExists) {
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		}
 		if err != nil {
 			zap.L().Error("error inserting server", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).SendString("error inserting server")
 		}
 
-		err = servers.InitServer(server)
+		err = servers.InitServer(*created)
 		if err != nil {
 			zap.L().Error("error initializing server", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).SendString("error initializing server")
 		}
 
-		return c.JSON(map[string]interface{}{
-			"id":          server.Id,
-			"name":        server.Name,
-			"port":        server.Port,
-			"host":        server.Host,
-			"version":     server.Version,
-			"description": server.Description,
-			"createdAt":   time.Now().Format(time.RFC3339),
-		})
+		return c.JSON(created)
 	})
 
-	app.Delete("/api/servers/:id", func(c *fiber.Ctx) error {
-		db := database.Get()
+	app.Patch("/api/servers/:id", middleware.RequireServer(auth.PermSettings), func(c *fiber.Ctx) error {
+		var patch servers.ServerPatch
+		if err := c.BodyParser(&patch); err != nil {
+			zap.L().Error("error parsing request body", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).SendString("invalid request body")
+		}
+
+		server, err := servers.UpdateServer(c.Params("id"), patch)
+		switch {
+		case errors.Is(err, servers.ErrServerNotFound):
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		case errors.Is(err, servers.ErrInvalidServer):
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		case errors.Is(err, servers.ErrPortInUse):
+			return sendPortConflict(c, err)
+		case err != nil:
+			zap.L().Error("error updating server", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error updating server")
+		}
+
+		return c.JSON(server)
+	})
 
+	app.Delete("/api/servers/:id", middleware.RequireServer(auth.PermDelete), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
-		_, err := db.Client.Exec(`
-		DELETE FROM servers WHERE id = ?
-	`, id)
+		err := servers.Repository().Delete(id)
+		if errors.Is(err, servers.ErrServerNotFound) {
+			err = nil
+		}
 
 		serverPath := utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + id)
+		servers.DeleteConsole(id)
+		if err := servers.DeleteStatsHistory(id); err != nil {
+			zap.L().Error("error deleting server stats history", zap.Error(err))
+		}
+		if err := auth.DeleteServerGrants(id); err != nil {
+			zap.L().Error("error deleting server grants", zap.Error(err))
+		}
 
 		if utils.IsFileExists(serverPath) {
 			if err := os.RemoveAll(serverPath); err != nil {
@@ -183,13 +204,41 @@ func RegisterApiRoutes(app *fiber.App) {
 		})
 	})
 
-	app.Post("/api/servers/start/:id", func(c *fiber.Ctx) error {
+	app.Get("/api/channels", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		return c.JSON(channels.List())
+	})
+
+	app.Get("/api/ports/suggest", func(c *fiber.Ctx) error {
+		host := c.Query("host", "0.0.0.0")
+		if !utils.IsValidIP(host) {
+			return c.Status(fiber.StatusBadRequest).SendString("invalid host")
+		}
+
+		port, err := servers.SuggestPort(host)
+		if errors.Is(err, servers.ErrNoFreePort) {
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		if err != nil {
+			zap.L().Error("error suggesting port", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error suggesting port")
+		}
+
+		return c.JSON(map[string]interface{}{
+			"host": host,
+			"port": port,
+		})
+	})
+
+	app.Post("/api/servers/start/:id", middleware.RequireServer(auth.PermPower), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
 		}
 
 		err := servers.StartServer(id)
+		if errors.Is(err, servers.ErrPortInUse) {
+			return sendPortConflict(c, err)
+		}
 		if err != nil {
 			zap.L().Error("error starting server", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).SendString("error starting server")
@@ -198,7 +247,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Post("/api/servers/stop/:id", func(c *fiber.Ctx) error {
+	app.Post("/api/servers/stop/:id", middleware.RequireServer(auth.PermPower), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -213,7 +262,199 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Get("/api/servers/logs/:id", func(c *fiber.Ctx) error {
+	app.Post("/api/servers/:id/kill", middleware.RequireServer(auth.PermPower), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		err := servers.KillServer(id)
+		if err != nil {
+			zap.L().Error("error killing server", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error killing server")
+		}
+
+		return c.SendString("ok")
+	})
+
+	app.Post("/api/servers/:id/command", middleware.RequireServer(auth.PermConsole), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		var request struct {
+			Command string `json:"command"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			zap.L().Error("error parsing request body", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).SendString("invalid request body")
+		}
+
+		command := strings.TrimPrefix(strings.TrimSpace(request.Command), "/")
+		if command == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("command is required")
+		}
+
+		if state := activeServers.GetState(id); state != activeServers.StateRunning {
+			return c.Status(fiber.StatusConflict).SendString("server is " + string(state))
+		}
+
+		client := channels.Client{
+			Addr:      c.IP(),
+			UserAgent: c.Get(fiber.HeaderUserAgent),
+			Username:  auth.Current(c).User.Username,
+		}
+		output, err := servers.SendCommand(id, command, client)
+		if err != nil {
+			zap.L().Error("error running command", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error running command")
+		}
+
+		return c.JSON(map[string]string{
+			"command": command,
+			"output":  output,
+		})
+	})
+
+	app.Get("/api/servers/:id/command/history", middleware.RequireServer(auth.PermConsole), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		limit := c.QueryInt("limit", 100)
+		if limit <= 0 || limit > 1000 {
+			return c.Status(fiber.StatusBadRequest).SendString("limit must be between 1 and 1000")
+		}
+
+		var before int64
+		if raw := c.Query("before"); raw != "" {
+			parsed, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || parsed < 0 {
+				return c.Status(fiber.StatusBadRequest).SendString("invalid before value")
+			}
+			before = parsed
+		}
+
+		history, err := servers.GetCommandHistory(id, before, limit)
+		if err != nil {
+			zap.L().Error("error getting command history", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error getting command history")
+		}
+
+		return c.JSON(history)
+	})
+
+	app.Get("/api/servers/:id/command/complete", middleware.RequireServer(auth.PermConsole), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		result, err := servers.CompleteCommand(id, c.Query("input"))
+		if err != nil {
+			zap.L().Error("error completing command", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error completing command")
+		}
+
+		return c.JSON(result)
+	})
+
+	app.Get("/api/servers/:id/restarts", middleware.RequireServer(auth.PermView), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		restarts, err := servers.GetServerRestarts(id)
+		if err != nil {
+			zap.L().Error("error getting server restarts", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error getting server restarts")
+		}
+
+		return c.JSON(restarts)
+	})
+
+	app.Get("/api/servers/:id/stats/history", middleware.RequireServer(auth.PermView), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		to := time.Now()
+		if raw := c.Query("to"); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("invalid to time")
+			}
+			to = parsed
+		}
+
+		from := to.Add(-time.Hour)
+		if raw := c.Query("from"); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("invalid from time")
+			}
+			from = parsed
+		}
+
+		if from.After(to) {
+			return c.Status(fiber.StatusBadRequest).SendString("from must be before to")
+		}
+
+		resolution := servers.DefaultStatsResolution(from)
+		if raw := c.Query("resolution"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed < time.Second {
+				return c.Status(fiber.StatusBadRequest).SendString("invalid resolution")
+			}
+			resolution = parsed
+		}
+
+		samples, err := servers.GetStatsHistory(id, from, to, resolution)
+		if err != nil {
+			zap.L().Error("error getting server stats history", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error getting server stats history")
+		}
+
+		return c.JSON(map[string]interface{}{
+			"from":       from.Format(time.RFC3339),
+			"to":         to.Format(time.RFC3339),
+			"resolution": resolution.String(),
+			"samples":    samples,
+		})
+	})
+
+	app.Get("/api/servers/:id/console", middleware.RequireServer(auth.PermConsole), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		var since uint64
+		if raw := c.Query("since"); raw != "" {
+			parsed, err := strconv.ParseUint(raw, 10, 64)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("invalid since value")
+			}
+			since = parsed
+		}
+
+		lines := servers.GetConsole(id, since)
+		next := since
+		if len(lines) > 0 {
+			next = lines[len(lines)-1].Seq
+		}
+
+		return c.JSON(map[string]interface{}{
+			"lines": lines,
+			"next":  next,
+		})
+	})
+
+	app.Get("/api/servers/logs/:id", middleware.RequireServer(auth.PermConsole), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -228,7 +469,115 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.JSON(logs)
 	})
 
-	app.Get("/api/servers/:id/world", func(c *fiber.Ctx) error {
+	app.Get("/api/servers/:id/logs", middleware.RequireServer(auth.PermConsole), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		files, err := servers.ListLogFiles(id)
+		if err != nil {
+			zap.L().Error("error listing server log files", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error listing server log files")
+		}
+
+		return c.JSON(files)
+	})
+
+	app.Get("/api/servers/:id/logs/search", middleware.RequireServer(auth.PermConsole), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		query := servers.LogQuery{
+			Thread:   c.Query("thread"),
+			Contains: c.Query("q"),
+			Context:  c.QueryInt("context", 0),
+			Limit:    c.QueryInt("limit", 1000),
+		}
+		if query.Context < 0 || query.Context > 50 || query.Limit <= 0 || query.Limit > 10000 {
+			return c.Status(fiber.StatusBadRequest).SendString("invalid context or limit")
+		}
+
+		if level := c.Query("level"); level != "" {
+			query.Levels = strings.Split(level, ",")
+		}
+
+		for name, target := range map[string]**time.Time{"from": &query.From, "to": &query.To} {
+			raw := c.Query(name)
+			if raw == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("invalid " + name + " value, expected RFC3339")
+			}
+			*target = &t
+		}
+
+		if pattern := c.Query("regex"); pattern != "" {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("invalid regex: " + err.Error())
+			}
+			query.Regex = re
+		}
+
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			encoder := json.NewEncoder(w)
+			err := servers.SearchLogs(id, query, func(match servers.LogMatch) error {
+				if err := encoder.Encode(match); err != nil {
+					return err
+				}
+				return w.Flush()
+			})
+			if err != nil {
+				zap.L().Error("error searching server logs", zap.String("id", id), zap.Error(err))
+			}
+		})
+
+		return nil
+	})
+
+	app.Get("/api/servers/:id/logs/:file", middleware.RequireServer(auth.PermConsole), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		offset := c.QueryInt("offset", 0)
+		limit := c.QueryInt("limit", 1000)
+		if offset < 0 || limit <= 0 || limit > 10000 {
+			return c.Status(fiber.StatusBadRequest).SendString("invalid offset or limit")
+		}
+
+		page, err := servers.ReadLogLines(id, c.Params("file"), offset, limit)
+		if err != nil {
+			zap.L().Error("error reading server log file", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error reading server log file")
+		}
+
+		return c.JSON(page)
+	})
+
+	app.Get("/api/servers/:id/logs/:file/download", middleware.RequireServer(auth.PermConsole), func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if id == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
+		}
+
+		path, err := servers.GetLogFilePath(id, c.Params("file"))
+		if err != nil {
+			zap.L().Error("error resolving server log file", zap.Error(err))
+			return c.Status(fiber.StatusNotFound).SendString("log file not found")
+		}
+
+		return c.Download(path)
+	})
+
+	app.Get("/api/servers/:id/world", middleware.RequireServer(auth.PermFiles), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -243,7 +592,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.JSON(map[string]string{"name": worldName, "path": worldPath, "seed": worldSeed, "type": worldType})
 	})
 
-	app.Post("/api/servers/:id/world/upload", func(c *fiber.Ctx) error {
+	app.Post("/api/servers/:id/world/upload", middleware.RequireServer(auth.PermFiles), func(c *fiber.Ctx) error {
 		fileHeader, err := c.FormFile("file")
 		if err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "file is required")
@@ -262,7 +611,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Get("/api/servers/:id/properties", func(c *fiber.Ctx) error {
+	app.Get("/api/servers/:id/properties", middleware.RequireServer(auth.PermSettings), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -274,10 +623,11 @@ func RegisterApiRoutes(app *fiber.App) {
 			return c.Status(fiber.StatusInternalServerError).SendString("error getting server properties")
 		}
 
-		return c.JSON(props.Map())
+		values := props.Map()
+		delete(values, "rcon.password")
+		return c.JSON(values)
 	})
-	app.Post("/api/servers/:id/properties", func(c *fiber.Ctx) error {
-		db := database.Get()
+	app.Post("/api/servers/:id/properties", middleware.RequireServer(auth.PermSettings), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -291,27 +641,23 @@ func RegisterApiRoutes(app *fiber.App) {
 		propsFile := utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + id + "/server.properties")
 		props := properties.NewProperties()
 
+		// the port and host are part of the server record, changes to them go through
+		// UpdateServer like any other edit
+		var patch servers.ServerPatch
 		for key, value := range newProps {
 			if key == "server-port" {
 				port, err := strconv.Atoi(value)
-				if err != nil || port <= 0 || port > 65535 {
-					return c.Status(fiber.StatusBadRequest).SendString("invalid server-port value")
-				}
-				_, err = db.Client.Exec("UPDATE servers SET port = ? WHERE id = ?", port, id)
 				if err != nil {
-					zap.L().Error("failed to update server port in database", zap.Error(err))
-					return c.Status(fiber.StatusInternalServerError).SendString("failed to update server port")
+					return c.Status(fiber.StatusBadRequest).SendString("invalid server-port value")
 				}
+				patch.Port = &port
 			} else if key == "server-ip" {
-				if value != "" && !utils.IsValidIP(value) {
-					return c.Status(fiber.StatusBadRequest).SendString("invalid server-ip value")
-				}
-
-				_, err := db.Client.Exec("UPDATE servers SET host = ? WHERE id = ?", value, id)
-				if err != nil {
-					zap.L().Error("failed to update server host in database", zap.Error(err))
-					return c.Status(fiber.StatusInternalServerError).SendString("failed to update server host")
+				// an empty server-ip listens on every address
+				host := value
+				if host == "" {
+					host = "0.0.0.0"
 				}
+				patch.Host = &host
 			}
 
 			err := props.SetValue(key, value)
@@ -320,6 +666,28 @@ func RegisterApiRoutes(app *fiber.App) {
 			}
 		}
 
+		_, err := servers.UpdateServer(id, patch)
+		switch {
+		case errors.Is(err, servers.ErrServerNotFound):
+			return c.Status(fiber.StatusNotFound).SendString(err.Error())
+		case errors.Is(err, servers.ErrInvalidServer):
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		case errors.Is(err, servers.ErrPortInUse):
+			return sendPortConflict(c, err)
+		case err != nil:
+			zap.L().Error("error updating server", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error updating server")
+		}
+
+		// clients never see the RCON password, so they send it back empty
+		if newProps["rcon.password"] == "" {
+			if config, err := servers.LoadServerConfig(id); err == nil && config.Rcon.Password != "" {
+				if _, _, err := props.Set("rcon.password", config.Rcon.Password); err != nil {
+					return err
+				}
+			}
+		}
+
 		f, err := os.Create(propsFile)
 		if err != nil {
 			zap.L().Error("failed to open properties file for writing", zap.Error(err))
@@ -335,7 +703,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendStatus(fiber.StatusOK)
 	})
 
-	app.Get("/api/servers/:id/config", func(c *fiber.Ctx) error {
+	app.Get("/api/servers/:id/config", middleware.RequireServer(auth.PermSettings), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -349,7 +717,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.JSON(config)
 	})
 
-	app.Post("/api/servers/:id/config", func(c *fiber.Ctx) error {
+	app.Post("/api/servers/:id/config", middleware.RequireServer(auth.PermSettings), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -361,6 +729,16 @@ func RegisterApiRoutes(app *fiber.App) {
 			return c.Status(fiber.StatusBadRequest).SendString("invalid request body")
 		}
 
+		// the RCON password is not part of the JSON, keep the stored one
+		if current, err := servers.LoadServerConfig(id); err == nil {
+			if config.Rcon.Password == "" {
+				config.Rcon.Password = current.Rcon.Password
+			}
+			if config.Rcon.Port == 0 {
+				config.Rcon.Port = current.Rcon.Port
+			}
+		}
+
 		if err := servers.SaveServerConfig(id, &config); err != nil {
 			zap.L().Error("error saving server config", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).SendString("error saving server config")
@@ -369,7 +747,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendStatus(fiber.StatusOK)
 	})
 
-	app.Get("/api/servers/:id/players", func(c *fiber.Ctx) error {
+	app.Get("/api/servers/:id/players", middleware.RequireServer(auth.PermView), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -408,7 +786,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.Type("application/json").Send(body)
 	})
 
-	app.Post("/api/servers/:id/backup", func(c *fiber.Ctx) error {
+	app.Post("/api/servers/:id/backup", middleware.RequireServer(auth.PermBackups), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -423,7 +801,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Post("/api/servers/:id/restore", func(c *fiber.Ctx) error {
+	app.Post("/api/servers/:id/restore", middleware.RequireServer(auth.PermBackups), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -451,7 +829,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Get("/api/servers/:id/backups", func(c *fiber.Ctx) error {
+	app.Get("/api/servers/:id/backups", middleware.RequireServer(auth.PermBackups), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -466,7 +844,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.JSON(backups)
 	})
 
-	app.Delete("/api/servers/:id/backups", func(c *fiber.Ctx) error {
+	app.Delete("/api/servers/:id/backups", middleware.RequireServer(auth.PermBackups), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -494,7 +872,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Post("/api/servers/:id/plugins", func(c *fiber.Ctx) error {
+	app.Post("/api/servers/:id/plugins", middleware.RequireServer(auth.PermPlugins), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -522,7 +900,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Get("/api/servers/:id/plugins", func(c *fiber.Ctx) error {
+	app.Get("/api/servers/:id/plugins", middleware.RequireServer(auth.PermPlugins), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -537,7 +915,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.JSON(installedPlugins)
 	})
 
-	app.Delete("/api/servers/:id/plugins/:pluginName", func(c *fiber.Ctx) error {
+	app.Delete("/api/servers/:id/plugins/:pluginName", middleware.RequireServer(auth.PermPlugins), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -557,7 +935,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Get("/api/servers/:id/plugins/manifest", func(c *fiber.Ctx) error {
+	app.Get("/api/servers/:id/plugins/manifest", middleware.RequireServer(auth.PermPlugins), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -574,7 +952,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.JSON(manifest)
 	})
 
-	app.Post("/api/servers/:id/plugins/manifest", func(c *fiber.Ctx) error {
+	app.Post("/api/servers/:id/plugins/manifest", middleware.RequireServer(auth.PermPlugins), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -603,7 +981,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Delete("/api/servers/:id/plugins/manifest/:pluginId", func(c *fiber.Ctx) error {
+	app.Delete("/api/servers/:id/plugins/manifest/:pluginId", middleware.RequireServer(auth.PermPlugins), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing server ID")
@@ -622,7 +1000,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 
-	app.Get("/api/settings", func(c *fiber.Ctx) error {
+	app.Get("/api/settings", middleware.RequireAdmin, func(c *fiber.Ctx) error {
 		settings, err := internal.LoadSettings()
 		if err != nil {
 			zap.L().Error("error loading settings", zap.Error(err))
@@ -642,7 +1020,7 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.JSON(settings)
 	})
 
-	app.Post("/api/settings", func(c *fiber.Ctx) error {
+	app.Post("/api/settings", middleware.RequireAdmin, func(c *fiber.Ctx) error {
 		var newSettings internal.Settings
 		if err := c.BodyParser(&newSettings); err != nil {
 			zap.L().Error("error parsing request body", zap.Error(err))
@@ -653,6 +1031,12 @@ func RegisterApiRoutes(app *fiber.App) {
 			newSettings.BasePath = internal.WatercolorDirectory
 		}
 
+		if newSettings.PortRangeStart != 0 || newSettings.PortRangeEnd != 0 {
+			if newSettings.PortRangeStart <= 0 || newSettings.PortRangeEnd < newSettings.PortRangeStart || newSettings.PortRangeEnd > 65535 {
+				return c.Status(fiber.StatusBadRequest).SendString("invalid port range")
+			}
+		}
+
 		if err := newSettings.Save(); err != nil {
 			zap.L().Error("error saving settings", zap.Error(err))
 			return c.Status(fiber.StatusInternalServerError).SendString("error saving settings")
@@ -663,3 +1047,12 @@ func RegisterApiRoutes(app *fiber.App) {
 		return c.SendString("ok")
 	})
 }
+
+// sendPortConflict answers with a 409 naming whoever holds the port
+func sendPortConflict(c *fiber.Ctx, err error) error {
+	var conflict *servers.PortConflict
+	if errors.As(err, &conflict) {
+		return c.Status(fiber.StatusConflict).JSON(conflict)
+	}
+	return c.Status(fiber.StatusConflict).SendString(err.Error())
+}
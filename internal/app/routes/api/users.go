@@ -0,0 +1,196 @@
+package api
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"strconv"
+	"watercolormc/internal/app/auth"
+	"watercolormc/internal/app/channels"
+	"watercolormc/internal/app/middleware"
+	"watercolormc/internal/app/servers"
+)
+
+func registerUserRoutes(app *fiber.App) {
+	app.Get("/api/users", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		users, err := auth.ListUsers()
+		if err != nil {
+			zap.L().Error("error listing users", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error listing users")
+		}
+
+		return c.JSON(users)
+	})
+
+	app.Post("/api/users", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		var request struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Role     string `json:"role"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			zap.L().Error("error parsing request body", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).SendString("invalid request body")
+		}
+		if request.Role == "" {
+			request.Role = auth.RoleMember
+		}
+
+		user, err := auth.CreateUser(request.Username, request.Password, request.Role)
+		if errors.Is(err, auth.ErrUserExists) {
+			return c.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		if err != nil {
+			zap.L().Error("error creating user", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		return c.JSON(user)
+	})
+
+	app.Put("/api/users/:userId/role", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		user, ok := userParam(c)
+		if !ok {
+			return nil
+		}
+
+		var request struct {
+			Role string `json:"role"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			zap.L().Error("error parsing request body", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).SendString("invalid request body")
+		}
+
+		err := auth.SetRole(user.Id, request.Role)
+		if errors.Is(err, auth.ErrUnknownRole) || errors.Is(err, auth.ErrLastAdmin) {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		if err != nil {
+			zap.L().Error("error setting role", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error setting role")
+		}
+		// open websockets were authorized with the old role
+		channels.DisconnectUser(user.Id)
+
+		return c.SendString("ok")
+	})
+
+	app.Delete("/api/users/:userId", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		user, ok := userParam(c)
+		if !ok {
+			return nil
+		}
+
+		err := auth.DeleteUser(user.Id)
+		if errors.Is(err, auth.ErrLastAdmin) {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		if err != nil {
+			zap.L().Error("error deleting user", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error deleting user")
+		}
+		channels.DisconnectUser(user.Id)
+
+		return c.SendString("ok")
+	})
+
+	app.Get("/api/users/:userId/grants", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		user, ok := userParam(c)
+		if !ok {
+			return nil
+		}
+
+		grants, err := auth.GetUserGrants(user.Id)
+		if err != nil {
+			zap.L().Error("error loading grants", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error loading grants")
+		}
+
+		return c.JSON(grants)
+	})
+
+	app.Get("/api/servers/:id/grants", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		grants, err := auth.GetGrants(c.Params("id"))
+		if err != nil {
+			zap.L().Error("error loading grants", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error loading grants")
+		}
+
+		return c.JSON(grants)
+	})
+
+	app.Put("/api/servers/:id/grants/:userId", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		user, ok := userParam(c)
+		if !ok {
+			return nil
+		}
+
+		if _, err := servers.Repository().Get(id); errors.Is(err, servers.ErrServerNotFound) {
+			return c.Status(fiber.StatusNotFound).SendString("server not found")
+		} else if err != nil {
+			zap.L().Error("error querying server", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error querying server")
+		}
+
+		var request struct {
+			Preset      string            `json:"preset"`
+			Permissions []auth.Permission `json:"permissions"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			zap.L().Error("error parsing request body", zap.Error(err))
+			return c.Status(fiber.StatusBadRequest).SendString("invalid request body")
+		}
+
+		permissions, err := auth.ExpandGrant(request.Preset, request.Permissions)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		if err := auth.SetGrant(user.Id, id, permissions); err != nil {
+			zap.L().Error("error saving grant", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error saving grant")
+		}
+		channels.DisconnectUser(user.Id)
+
+		return c.JSON(auth.Grant{UserId: user.Id, Username: user.Username, ServerId: id, Permissions: permissions})
+	})
+
+	app.Delete("/api/servers/:id/grants/:userId", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		user, ok := userParam(c)
+		if !ok {
+			return nil
+		}
+
+		if err := auth.DeleteGrant(user.Id, c.Params("id")); err != nil {
+			zap.L().Error("error deleting grant", zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).SendString("error deleting grant")
+		}
+		channels.DisconnectUser(user.Id)
+
+		return c.SendString("ok")
+	})
+}
+
+// userParam loads the user named by the userId route parameter. If it returns false
+// the error has already been written to the response.
+func userParam(c *fiber.Ctx) (*auth.User, bool) {
+	id, err := strconv.ParseInt(c.Params("userId"), 10, 64)
+	if err != nil {
+		_ = c.Status(fiber.StatusBadRequest).SendString("invalid user ID")
+		return nil, false
+	}
+
+	user, err := auth.GetUser(id)
+	if err != nil {
+		zap.L().Error("error loading user", zap.Error(err))
+		_ = c.Status(fiber.StatusInternalServerError).SendString("error loading user")
+		return nil, false
+	}
+	if user == nil {
+		_ = c.Status(fiber.StatusNotFound).SendString("user not found")
+		return nil, false
+	}
+	return user, true
+}
@@ -2,9 +2,17 @@ package routes
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"watercolormc/internal/app/middleware"
 	"watercolormc/internal/app/routes/api"
+	"watercolormc/internal/metrics"
 )
 
 func Setup(app *fiber.App) {
 	api.RegisterApiRoutes(app)
+
+	// scrapers authenticate with an admin's API token as a bearer token
+	app.Get("/metrics", middleware.RequireAdmin, func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		return metrics.Write(c)
+	})
 }
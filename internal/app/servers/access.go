@@ -0,0 +1,39 @@
+package servers
+
+import (
+	"go.uber.org/zap"
+	"strings"
+	"watercolormc/internal/app/auth"
+)
+
+// permissions needed per kind of server channel, named server:<kind>:<id>
+var channelPermissions = map[string]auth.Permission{
+	"stdout":  auth.PermConsole,
+	"stderr":  auth.PermConsole,
+	"stdin":   auth.PermConsole,
+	"events":  auth.PermConsole,
+	"stats":   auth.PermView,
+	"info":    auth.PermView,
+	"players": auth.PermView,
+}
+
+// AuthorizeChannel decides whether a user may use a websocket channel. Server
+// channels need a grant on the server; other channels are open to every user.
+func AuthorizeChannel(user *auth.User, channel string) bool {
+	parts := strings.SplitN(channel, ":", 3)
+	if len(parts) != 3 || parts[0] != "server" {
+		return true
+	}
+
+	permission, ok := channelPermissions[parts[1]]
+	if !ok {
+		permission = auth.PermView
+	}
+
+	allowed, err := auth.Can(user, parts[2], permission)
+	if err != nil {
+		zap.L().Error("failed to check channel permission", zap.String("channel", channel), zap.Error(err))
+		return false
+	}
+	return allowed
+}
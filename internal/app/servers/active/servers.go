@@ -1,17 +1,33 @@
 package activeServers
 
 import (
-	"bytes"
-	"io"
 	"sync"
+	"time"
 )
 import (
 	"github.com/xDefyingGravity/gomcserver"
+	"watercolormc/internal/app/servers/console"
+)
+
+type State string
+
+const (
+	StateInstalling State = "installing"
+	StateStarting   State = "starting"
+	StateRunning    State = "running"
+	StateStopping   State = "stopping"
+	StateStopped    State = "stopped"
+	StateKilled     State = "killed"
+	StateCrashed    State = "crashed"
+	StateFailed     State = "failed" // installing or launching the server failed
 )
 
 type Store struct {
 	mu      sync.RWMutex
 	servers map[string]Server
+
+	// lastStates remembers how servers that are no longer active ended
+	lastStates map[string]State
 }
 
 type Server struct {
@@ -23,17 +39,23 @@ type Server struct {
 	Description string
 	CreatedAt   string
 
-	StdoutBuffer *bytes.Buffer
-	StderrBuffer *bytes.Buffer
+	Console *console.Buffer
 
-	StdoutWriter io.Writer
-	StderrWriter io.Writer
+	PID        int
+	StartedAt  time.Time
+	Reattached bool
+	Done       chan struct{}
+	Exit       chan int
+
+	State      State
+	StopReason string
 
 	Instance *gomcserver.Server
 }
 
 var globalStore = &Store{
-	servers: make(map[string]Server),
+	servers:    make(map[string]Server),
+	lastStates: make(map[string]State),
 }
 
 func Get(id string) (Server, bool) {
@@ -51,6 +73,20 @@ func Add(srv Server) {
 	globalStore.servers[srv.ID] = srv
 }
 
+// Update applies fn to the stored server, returning false if it is not active.
+func Update(id string, fn func(*Server)) bool {
+	globalStore.mu.Lock()
+	defer globalStore.mu.Unlock()
+
+	srv, ok := globalStore.servers[id]
+	if !ok {
+		return false
+	}
+	fn(&srv)
+	globalStore.servers[id] = srv
+	return true
+}
+
 func Remove(id string) {
 	globalStore.mu.Lock()
 	defer globalStore.mu.Unlock()
@@ -69,6 +105,29 @@ func List() []Server {
 	return servers
 }
 
+// GetState returns the state of an active server, or the state an inactive server
+// ended in.
+func GetState(id string) State {
+	globalStore.mu.RLock()
+	defer globalStore.mu.RUnlock()
+
+	if srv, ok := globalStore.servers[id]; ok {
+		return srv.State
+	}
+	if state, ok := globalStore.lastStates[id]; ok {
+		return state
+	}
+	return StateStopped
+}
+
+// SetLastState records the final state of a server that is being removed.
+func SetLastState(id string, state State) {
+	globalStore.mu.Lock()
+	defer globalStore.mu.Unlock()
+
+	globalStore.lastStates[id] = state
+}
+
 func IsOnline(id string) bool {
 	globalStore.mu.RLock()
 	defer globalStore.mu.RUnlock()
@@ -0,0 +1,435 @@
+package servers
+
+import (
+	"archive/zip"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"watercolormc/internal"
+	activeServers "watercolormc/internal/app/servers/active"
+	"watercolormc/internal/utils"
+)
+
+const maxCompletions = 50
+
+// argument placeholders in command usages that can be completed
+const (
+	argPlayer    = "<player>"
+	argTargets   = "<targets>"
+	argDimension = "<dimension>"
+	// used for plugin commands, whose arguments are unknown
+	argAny = "<any>"
+)
+
+// vanillaCommands lists the usages of the built-in commands. A usage is a list of
+// space separated arguments: plain words and <a|b|c> are literals, <player>,
+// <targets> and <dimension> are completed from the server, anything else in angle
+// or square brackets accepts any value.
+var vanillaCommands = map[string][]string{
+	"advancement":     {"<grant|revoke> <targets> <everything|only|from|through|until>"},
+	"attribute":       {"<targets>"},
+	"ban":             {"<targets> [reason]"},
+	"ban-ip":          {"<target> [reason]"},
+	"banlist":         {"<ips|players>"},
+	"bossbar":         {"<add|get|list|remove|set>"},
+	"clear":           {"<targets> [item] [maxCount]"},
+	"clone":           {"<begin> <end> <destination>"},
+	"damage":          {"<targets> <amount>"},
+	"data":            {"<get|merge|modify|remove> <block|entity|storage>"},
+	"datapack":        {"<disable|enable|list>"},
+	"debug":           {"<start|stop|function>"},
+	"defaultgamemode": {"<survival|creative|adventure|spectator>"},
+	"deop":            {"<player>"},
+	"difficulty":      {"<peaceful|easy|normal|hard>"},
+	"effect":          {"<give|clear> <targets>"},
+	"enchant":         {"<targets> <enchantment> [level]"},
+	"execute":         {"<align|anchored|as|at|facing|if|in|on|positioned|rotated|run|store|summon|unless>", "in <dimension>", "<as|at> <targets>"},
+	"experience":      {"<add|set|query> <targets>"},
+	"fill":            {"<from> <to> <block>"},
+	"fillbiome":       {"<from> <to> <biome>"},
+	"forceload":       {"<add|remove|query>"},
+	"function":        {"<name>"},
+	"gamemode":        {"<survival|creative|adventure|spectator> <targets>"},
+	"gamerule":        {"<rule> [value]"},
+	"give":            {"<targets> <item> [count]"},
+	"help":            {"[command]"},
+	"item":            {"<modify|replace>"},
+	"jfr":             {"<start|stop>"},
+	"kick":            {"<targets> [reason]"},
+	"kill":            {"<targets>"},
+	"list":            {"[uuids]"},
+	"locate":          {"<biome|poi|structure>"},
+	"loot":            {"<give|insert|replace|spawn>"},
+	"me":              {"<action>"},
+	"msg":             {"<targets> <message>"},
+	"op":              {"<player>"},
+	"pardon":          {"<player>"},
+	"pardon-ip":       {"<target>"},
+	"particle":        {"<name>"},
+	"perf":            {"<start|stop>"},
+	"place":           {"<feature|jigsaw|structure|template>"},
+	"playsound":       {"<sound> <master|music|record|weather|block|hostile|neutral|player|ambient|voice> <targets>"},
+	"publish":         {"[allowCommands] [gamemode] [port]"},
+	"random":          {"<value|roll|reset>"},
+	"recipe":          {"<give|take> <targets>"},
+	"reload":          {},
+	"return":          {"<value>"},
+	"ride":            {"<targets> <mount|dismount>"},
+	"save-all":        {"[flush]"},
+	"save-off":        {},
+	"save-on":         {},
+	"say":             {"<message>"},
+	"schedule":        {"<function|clear>"},
+	"scoreboard":      {"<objectives|players>"},
+	"seed":            {},
+	"setblock":        {"<pos> <block>"},
+	"setidletimeout":  {"<minutes>"},
+	"setworldspawn":   {"[pos] [angle]"},
+	"spawnpoint":      {"<targets> [pos] [angle]"},
+	"spectate":        {"<targets> <player>"},
+	"spreadplayers":   {"<center> <spreadDistance> <maxRange>"},
+	"stop":            {},
+	"stopsound":       {"<targets>"},
+	"summon":          {"<entity> [pos]"},
+	"tag":             {"<targets> <add|list|remove>"},
+	"team":            {"<add|empty|join|leave|list|modify|remove>"},
+	"teammsg":         {"<message>"},
+	"teleport":        {"<targets> <destination>", "<targets> <location>"},
+	"tell":            {"<targets> <message>"},
+	"tellraw":         {"<targets> <message>"},
+	"time":            {"<add|query|set>", "set <day|night|noon|midnight>", "query <daytime|gametime|day>"},
+	"title":           {"<targets> <clear|reset|title|subtitle|actionbar|times>"},
+	"tm":              {"<message>"},
+	"tp":              {"<targets> <destination>", "<targets> <location>"},
+	"transfer":        {"<hostname> [port] [players]"},
+	"trigger":         {"<objective>"},
+	"w":               {"<targets> <message>"},
+	"weather":         {"<clear|rain|thunder> [duration]"},
+	"whitelist":       {"<add|remove> <player>", "<list|off|on|reload>"},
+	"worldborder":     {"<add|center|damage|get|set|warning>"},
+	"xp":              {"<add|set|query> <targets>"},
+}
+
+// selectors offered wherever entities can be targeted
+var targetSelectors = []string{"@a", "@e", "@p", "@r", "@s"}
+
+var vanillaDimensions = []string{"minecraft:overworld", "minecraft:the_nether", "minecraft:the_end"}
+
+type Completion struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// CompletionResult holds suggestions for the last word of the input, which starts
+// at byte offset Start.
+type CompletionResult struct {
+	Start       int          `json:"start"`
+	Completions []Completion `json:"completions"`
+}
+
+// PluginCommand is a command declared in an installed plugin's plugin.yml.
+type PluginCommand struct {
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Usage       string   `json:"usage,omitempty"`
+	Plugin      string   `json:"plugin"`
+}
+
+type pluginDescriptor struct {
+	Name     string `yaml:"name"`
+	Commands map[string]struct {
+		Description string      `yaml:"description"`
+		Usage       string      `yaml:"usage"`
+		Aliases     interface{} `yaml:"aliases"`
+	} `yaml:"commands"`
+}
+
+type cachedPluginCommands struct {
+	modified time.Time
+	size     int64
+	commands []PluginCommand
+}
+
+var (
+	pluginCommandsMu    sync.Mutex
+	pluginCommandsCache = make(map[string]cachedPluginCommands)
+)
+
+// GetPluginCommands reads the commands declared by the plugins installed on a
+// server. Jars are only read again when they change.
+func GetPluginCommands(id string) ([]PluginCommand, error) {
+	pluginsDir := filepath.Join(utils.ExpandHome(internal.WatercolorDirectory+"/servers/"+id), "plugins")
+	entries, err := os.ReadDir(pluginsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []PluginCommand{}, nil
+		}
+		return nil, err
+	}
+
+	commands := []PluginCommand{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".jar") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(pluginsDir, entry.Name())
+
+		pluginCommandsMu.Lock()
+		cached, ok := pluginCommandsCache[path]
+		pluginCommandsMu.Unlock()
+
+		if !ok || !cached.modified.Equal(info.ModTime()) || cached.size != info.Size() {
+			parsed, err := readPluginCommands(path)
+			if err != nil {
+				// not every jar in the folder is a Bukkit plugin
+				parsed = nil
+			}
+			cached = cachedPluginCommands{modified: info.ModTime(), size: info.Size(), commands: parsed}
+
+			pluginCommandsMu.Lock()
+			pluginCommandsCache[path] = cached
+			pluginCommandsMu.Unlock()
+		}
+
+		commands = append(commands, cached.commands...)
+	}
+
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands, nil
+}
+
+func readPluginCommands(path string) ([]PluginCommand, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.Name != "plugin.yml" {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, 1024*1024))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var descriptor pluginDescriptor
+		if err := yaml.Unmarshal(data, &descriptor); err != nil {
+			return nil, err
+		}
+
+		commands := make([]PluginCommand, 0, len(descriptor.Commands))
+		for name, command := range descriptor.Commands {
+			commands = append(commands, PluginCommand{
+				Name:        strings.ToLower(name),
+				Aliases:     parseAliases(command.Aliases),
+				Description: command.Description,
+				Usage:       command.Usage,
+				Plugin:      descriptor.Name,
+			})
+		}
+		return commands, nil
+	}
+
+	return nil, nil
+}
+
+// aliases may be written as a single string or as a list
+func parseAliases(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{strings.ToLower(v)}
+	case []interface{}:
+		aliases := make([]string, 0, len(v))
+		for _, alias := range v {
+			if s, ok := alias.(string); ok {
+				aliases = append(aliases, strings.ToLower(s))
+			}
+		}
+		return aliases
+	}
+	return nil
+}
+
+// GetWorldNames lists the world folders of a server.
+func GetWorldNames(id string) []string {
+	serverFolder := utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + id)
+	entries, err := os.ReadDir(serverFolder)
+	if err != nil {
+		return nil
+	}
+
+	var worlds []string
+	for _, entry := range entries {
+		if entry.IsDir() && utils.IsFileExists(filepath.Join(serverFolder, entry.Name(), "level.dat")) {
+			worlds = append(worlds, entry.Name())
+		}
+	}
+	return worlds
+}
+
+// CompleteCommand suggests completions for the last word of a partially typed
+// console command.
+func CompleteCommand(id string, input string) (*CompletionResult, error) {
+	offset := 0
+	if strings.HasPrefix(input, "/") {
+		offset = 1
+	}
+	input = input[offset:]
+
+	words := strings.Split(input, " ")
+	current := words[len(words)-1]
+	previous := words[:len(words)-1]
+
+	result := &CompletionResult{Start: offset + len(input) - len(current), Completions: []Completion{}}
+
+	pluginCommands, err := GetPluginCommands(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(previous) == 0 {
+		seen := make(map[string]bool)
+		add := func(name string, kind string) {
+			if !seen[name] && strings.HasPrefix(name, strings.ToLower(current)) {
+				seen[name] = true
+				result.Completions = append(result.Completions, Completion{Text: name, Type: kind})
+			}
+		}
+
+		for name := range vanillaCommands {
+			add(name, "command")
+		}
+		for _, command := range pluginCommands {
+			add(command.Name, "command")
+			for _, alias := range command.Aliases {
+				add(alias, "command")
+			}
+		}
+
+		sort.Slice(result.Completions, func(i, j int) bool {
+			return result.Completions[i].Text < result.Completions[j].Text
+		})
+		return truncateCompletions(result), nil
+	}
+
+	usages, ok := vanillaCommands[strings.ToLower(previous[0])]
+	if !ok {
+		// plugin commands don't declare their arguments, players and worlds are the
+		// best guess
+		usages = []string{strings.Repeat(argAny+" ", len(previous))}
+	}
+
+	seen := make(map[string]bool)
+	for _, usage := range usages {
+		argument, ok := usageArgument(usage, previous[1:])
+		if !ok {
+			continue
+		}
+		for _, completion := range argumentCompletions(id, argument) {
+			if !seen[completion.Text] && strings.HasPrefix(strings.ToLower(completion.Text), strings.ToLower(current)) {
+				seen[completion.Text] = true
+				result.Completions = append(result.Completions, completion)
+			}
+		}
+	}
+
+	return truncateCompletions(result), nil
+}
+
+// usageArgument returns the argument of a usage at the position after the given
+// words, if the words fit the usage so far.
+func usageArgument(usage string, words []string) (string, bool) {
+	arguments := strings.Fields(usage)
+	if len(words) >= len(arguments) {
+		return "", false
+	}
+
+	for i, word := range words {
+		if options := literalOptions(arguments[i]); options != nil {
+			matched := false
+			for _, option := range options {
+				if strings.EqualFold(option, word) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return "", false
+			}
+		}
+	}
+
+	return arguments[len(words)], true
+}
+
+// literalOptions returns the fixed values an argument accepts, or nil if it takes
+// a free value.
+func literalOptions(argument string) []string {
+	if !strings.HasPrefix(argument, "<") && !strings.HasPrefix(argument, "[") {
+		return []string{argument}
+	}
+	inner := strings.Trim(argument, "<>[]")
+	if strings.Contains(inner, "|") {
+		return strings.Split(inner, "|")
+	}
+	return nil
+}
+
+func argumentCompletions(id string, argument string) []Completion {
+	var completions []Completion
+
+	switch argument {
+	case argPlayer, argTargets:
+		if s, ok := activeServers.Get(id); ok && s.Instance != nil {
+			for _, player := range s.Instance.Players {
+				completions = append(completions, Completion{Text: player, Type: "player"})
+			}
+		}
+		if argument == argTargets {
+			for _, selector := range targetSelectors {
+				completions = append(completions, Completion{Text: selector, Type: "selector"})
+			}
+		}
+	case argDimension:
+		for _, dimension := range vanillaDimensions {
+			completions = append(completions, Completion{Text: dimension, Type: "dimension"})
+		}
+	case argAny:
+		completions = append(completions, argumentCompletions(id, argPlayer)...)
+		for _, world := range GetWorldNames(id) {
+			completions = append(completions, Completion{Text: world, Type: "world"})
+		}
+	default:
+		for _, option := range literalOptions(argument) {
+			completions = append(completions, Completion{Text: option, Type: "literal"})
+		}
+	}
+
+	return completions
+}
+
+func truncateCompletions(result *CompletionResult) *CompletionResult {
+	if len(result.Completions) > maxCompletions {
+		result.Completions = result.Completions[:maxCompletions]
+	}
+	return result
+}
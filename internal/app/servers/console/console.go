@@ -0,0 +1,173 @@
+package console
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultSize = 1000
+
+type Line struct {
+	Seq    uint64    `json:"seq"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// Buffer keeps the most recent console lines of a server in a fixed-size ring.
+// Output arrives in arbitrary chunks, so incomplete trailing lines are held back
+// per stream until their newline arrives.
+type Buffer struct {
+	mu      sync.Mutex
+	lines   []Line
+	start   int
+	count   int
+	nextSeq uint64
+	partial map[string]string
+}
+
+type Store struct {
+	mu      sync.RWMutex
+	buffers map[string]*Buffer
+}
+
+var globalStore = &Store{
+	buffers: make(map[string]*Buffer),
+}
+
+func NewBuffer(size int) *Buffer {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Buffer{
+		lines:   make([]Line, size),
+		nextSeq: 1,
+		partial: make(map[string]string),
+	}
+}
+
+// Get returns the buffer of a server, creating it with the given size if needed.
+// An existing buffer is resized when size differs from its capacity.
+func Get(id string, size int) *Buffer {
+	globalStore.mu.Lock()
+	defer globalStore.mu.Unlock()
+
+	buf, ok := globalStore.buffers[id]
+	if !ok {
+		buf = NewBuffer(size)
+		globalStore.buffers[id] = buf
+		return buf
+	}
+
+	buf.Resize(size)
+	return buf
+}
+
+// Lookup returns the buffer of a server if one exists.
+func Lookup(id string) (*Buffer, bool) {
+	globalStore.mu.RLock()
+	defer globalStore.mu.RUnlock()
+
+	buf, ok := globalStore.buffers[id]
+	return buf, ok
+}
+
+func Remove(id string) {
+	globalStore.mu.Lock()
+	defer globalStore.mu.Unlock()
+
+	delete(globalStore.buffers, id)
+}
+
+// Write splits a chunk of output into lines, stores the complete ones and returns
+// them with their sequence numbers.
+func (b *Buffer) Write(stream string, chunk string) []Line {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	text := b.partial[stream] + chunk
+	parts := strings.Split(text, "\n")
+	b.partial[stream] = parts[len(parts)-1]
+
+	now := time.Now()
+	written := make([]Line, 0, len(parts)-1)
+	for _, part := range parts[:len(parts)-1] {
+		line := Line{
+			Seq:    b.nextSeq,
+			Stream: stream,
+			Text:   strings.TrimSuffix(part, "\r"),
+			Time:   now,
+		}
+		b.nextSeq++
+		b.push(line)
+		written = append(written, line)
+	}
+
+	return written
+}
+
+func (b *Buffer) push(line Line) {
+	size := len(b.lines)
+	if b.count < size {
+		b.lines[(b.start+b.count)%size] = line
+		b.count++
+		return
+	}
+
+	b.lines[b.start] = line
+	b.start = (b.start + 1) % size
+}
+
+// Since returns the buffered lines with a sequence number greater than seq, oldest
+// first. Since(0) returns everything in the buffer.
+func (b *Buffer) Since(seq uint64) []Line {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lines := make([]Line, 0, b.count)
+	for i := 0; i < b.count; i++ {
+		line := b.lines[(b.start+i)%len(b.lines)]
+		if line.Seq > seq {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// LastSeq returns the sequence number of the newest line, or 0 if none was written.
+func (b *Buffer) LastSeq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.nextSeq - 1
+}
+
+// Resize changes the number of lines kept, dropping the oldest ones if it shrinks.
+func (b *Buffer) Resize(size int) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if size == len(b.lines) {
+		return
+	}
+
+	lines := make([]Line, size)
+	skip := 0
+	if b.count > size {
+		skip = b.count - size
+	}
+	n := 0
+	for i := skip; i < b.count; i++ {
+		lines[n] = b.lines[(b.start+i)%len(b.lines)]
+		n++
+	}
+
+	b.lines = lines
+	b.start = 0
+	b.count = n
+}
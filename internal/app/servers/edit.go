@@ -0,0 +1,125 @@
+package servers
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"watercolormc/internal"
+	"watercolormc/internal/app/channels"
+	"watercolormc/internal/utils"
+)
+
+var ErrInvalidServer = errors.New("invalid server")
+
+// ServerPatch holds the fields of a server that can be changed after it was
+// created. Nil fields are left as they are.
+type ServerPatch struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Version     *string `json:"version"`
+	Port        *int    `json:"port"`
+	Host        *string `json:"host"`
+}
+
+// UpdateServer applies a patch to a server and keeps its server.properties in step
+// with the new port and host. A running server picks up the changes on its next
+// start. Everyone allowed to see the server is told about the change on its
+// server:info channel.
+func UpdateServer(id string, patch ServerPatch) (*Server, error) {
+	current, err := Repository().Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *current
+	if patch.Name != nil {
+		updated.Name = strings.TrimSpace(*patch.Name)
+		if updated.Name == "" {
+			return nil, fmt.Errorf("%w: name must not be empty", ErrInvalidServer)
+		}
+	}
+	if patch.Description != nil {
+		updated.Description = *patch.Description
+	}
+	if patch.Version != nil {
+		version, err := utils.PreprocessVersion(strings.TrimSpace(*patch.Version))
+		if err != nil || version == "" {
+			return nil, fmt.Errorf("%w: invalid version", ErrInvalidServer)
+		}
+		updated.Version = version
+	}
+	if patch.Port != nil {
+		if *patch.Port <= 0 || *patch.Port > 65535 {
+			return nil, fmt.Errorf("%w: port must be between 1 and 65535", ErrInvalidServer)
+		}
+		updated.Port = *patch.Port
+	}
+	if patch.Host != nil {
+		if !utils.IsValidIP(*patch.Host) {
+			return nil, fmt.Errorf("%w: host must be an IP address", ErrInvalidServer)
+		}
+		updated.Host = *patch.Host
+	}
+
+	if updated == *current {
+		return current, nil
+	}
+
+	// the port may be free on the old host and taken on the new one
+	if updated.Port != current.Port || updated.Host != current.Host {
+		if err := CheckPort(id, updated.Host, updated.Port); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := Repository().Update(updated); err != nil {
+		return nil, err
+	}
+
+	if updated.Port != current.Port || updated.Host != current.Host {
+		if err := syncNetworkProperties(updated); err != nil {
+			if rollbackErr := Repository().Update(*current); rollbackErr != nil {
+				zap.L().Error("failed to roll back server update", zap.String("id", id), zap.Error(rollbackErr))
+			}
+			return nil, err
+		}
+	}
+
+	broadcastServerInfo(updated)
+	return &updated, nil
+}
+
+// syncNetworkProperties writes the port and host to server.properties. Servers that
+// were never started have no properties file yet and get both when they start.
+func syncNetworkProperties(server Server) error {
+	propertiesFile := utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + server.Id + "/server.properties")
+	if !utils.IsFileExists(propertiesFile) {
+		return nil
+	}
+
+	props, err := GetServerProperties(server.Id)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := props.Set("server-port", strconv.Itoa(server.Port)); err != nil {
+		return err
+	}
+	if _, _, err := props.Set("server-ip", server.Host); err != nil {
+		return err
+	}
+	return SaveServerProperties(server.Id, props)
+}
+
+func broadcastServerInfo(server Server) {
+	err := channels.BroadcastToChannel("server:info:"+server.Id, channels.Message{
+		Type:   channels.TypeServerUpdated,
+		Server: server.Id,
+		Data:   server,
+	})
+	if err != nil {
+		zap.L().Error("failed to broadcast server update", zap.String("id", server.Id), zap.Error(err))
+	}
+}
@@ -0,0 +1,74 @@
+package servers
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	activeServers "watercolormc/internal/app/servers/active"
+)
+
+// useMemoryRepository swaps in an in-memory repository and a scratch home directory
+// for the duration of a test
+func useMemoryRepository(t *testing.T, servers ...Server) *MemoryServerRepository {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	previous := Repository()
+	repo := NewMemoryServerRepository()
+	SetRepository(repo)
+	t.Cleanup(func() { SetRepository(previous) })
+
+	for _, s := range servers {
+		if _, err := repo.Create(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func TestUpdateServerHostOnlyWhileRunning(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	useMemoryRepository(t, Server{Id: "smp", Name: "smp", Port: port, Host: "127.0.0.1", Version: "1.21.1"})
+
+	// the test process stands in for the server's java process holding the port
+	activeServers.Add(activeServers.Server{ID: "smp", Name: "smp", Port: port, PID: os.Getpid(), State: activeServers.StateRunning})
+	defer activeServers.Remove("smp")
+
+	host := "0.0.0.0"
+	updated, err := UpdateServer("smp", ServerPatch{Host: &host})
+	if err != nil {
+		t.Fatalf("host-only edit of a running server failed: %v", err)
+	}
+	if updated.Host != host || updated.Port != port {
+		t.Fatalf("got %s:%d, want %s:%d", updated.Host, updated.Port, host, port)
+	}
+}
+
+func TestUpdateServerPortOnOwnRconPort(t *testing.T) {
+	repo := useMemoryRepository(t)
+	server, err := repo.Create(Server{Id: "smp", Name: "smp", Port: 41565, Host: "127.0.0.1", Version: "1.21.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InitServer(*server); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadServerConfig("smp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port := config.Rcon.Port
+	_, err = UpdateServer("smp", ServerPatch{Port: &port})
+	if !errors.Is(err, ErrPortInUse) {
+		t.Fatalf("moving the game port onto the server's own rcon port %d: got %v, want ErrPortInUse", port, err)
+	}
+}
@@ -0,0 +1,128 @@
+package servers
+
+import (
+	"go.uber.org/zap"
+	"regexp"
+	"strconv"
+	"time"
+	"watercolormc/internal/app/channels"
+)
+
+const (
+	EventChat        = "chat"
+	EventJoin        = "join"
+	EventLeave       = "leave"
+	EventDeath       = "death"
+	EventAdvancement = "advancement"
+	EventReady       = "ready"
+	EventStopping    = "stopping"
+	EventLag         = "lag"
+	EventCommand     = "command"
+	EventException   = "exception"
+)
+
+// ConsoleEvent is something recognised in a server's console output. Only the
+// fields relevant to its type are set.
+type ConsoleEvent struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level,omitempty"`
+	Player  string    `json:"player,omitempty"`
+	Message string    `json:"message,omitempty"`
+
+	Advancement string  `json:"advancement,omitempty"`
+	Kind        string  `json:"kind,omitempty"`
+	Seconds     float64 `json:"seconds,omitempty"`
+	BehindMs    int     `json:"behindMs,omitempty"`
+	BehindTicks int     `json:"behindTicks,omitempty"`
+	Command     string  `json:"command,omitempty"`
+	Exception   string  `json:"exception,omitempty"`
+}
+
+var (
+	chatPattern        = regexp.MustCompile(`^(?:\[Not Secure\] )?<([^>]+)> (.*)$`)
+	joinPattern        = regexp.MustCompile(`^(\w{1,16}) joined the game$`)
+	leavePattern       = regexp.MustCompile(`^(\w{1,16}) left the game$`)
+	advancementPattern = regexp.MustCompile(`^(\w{1,16}) has (made the advancement|completed the challenge|reached the goal) \[(.+)\]$`)
+	readyPattern       = regexp.MustCompile(`^Done \(([\d.]+)s\)! For help, type "help"`)
+	stoppingPattern    = regexp.MustCompile(`^(?:\[(\w{1,16}): )?Stopping the server\]?$`)
+	lagPattern         = regexp.MustCompile(`^Can't keep up! Is the server overloaded\? Running (\d+)ms or (\d+) ticks behind`)
+	commandPattern     = regexp.MustCompile(`^(\w{1,16}) issued server command: (.*)$`)
+	exceptionPattern   = regexp.MustCompile(`^(?:Exception in thread "[^"]*" )?((?:[a-zA-Z_$][\w$]*\.)+[A-Z][\w$]*(?:Exception|Error))(?::\s*(.*))?$`)
+	deathPattern       = regexp.MustCompile(`^(\w{1,16}) (` +
+		`was (?:slain|shot|fireballed|pummeled|killed|blown up|impaled|squashed|squished|skewered|obliterated|stung to death|pricked to death|poked to death|struck by lightning|burnt to a crisp|doomed to fall|frozen to death|speared)` +
+		`|drowned|died|blew up|burned to death|went up in flames|walked into fire|tried to swim in lava|suffocated in a wall` +
+		`|starved to death|froze to death|withered away|experienced kinetic energy|hit the ground too hard` +
+		`|fell (?:from|off|out of|into|while)|discovered the floor was lava|left the confines of this world` +
+		`|didn't want to live|went off with a bang|was killed by)\b.*$`)
+)
+
+var advancementKinds = map[string]string{
+	"made the advancement":    "advancement",
+	"completed the challenge": "challenge",
+	"reached the goal":        "goal",
+}
+
+// ParseConsoleEvent recognises a single line of console output, returning nil for
+// lines that carry no event.
+func ParseConsoleEvent(line string) *ConsoleEvent {
+	_, _, level, message, ok := ParseLogLine(line)
+
+	event := &ConsoleEvent{Time: time.Now(), Level: level}
+	if !ok {
+		// unprefixed lines are mostly stack traces, only their first line is of interest
+		if m := exceptionPattern.FindStringSubmatch(message); m != nil {
+			event.Type = EventException
+			event.Exception = m[1]
+			event.Message = m[2]
+			return event
+		}
+		return nil
+	}
+
+	switch {
+	case chatPattern.MatchString(message):
+		m := chatPattern.FindStringSubmatch(message)
+		event.Type, event.Player, event.Message = EventChat, m[1], m[2]
+	case joinPattern.MatchString(message):
+		event.Type, event.Player = EventJoin, joinPattern.FindStringSubmatch(message)[1]
+	case leavePattern.MatchString(message):
+		event.Type, event.Player = EventLeave, leavePattern.FindStringSubmatch(message)[1]
+	case advancementPattern.MatchString(message):
+		m := advancementPattern.FindStringSubmatch(message)
+		event.Type, event.Player, event.Kind, event.Advancement = EventAdvancement, m[1], advancementKinds[m[2]], m[3]
+	case readyPattern.MatchString(message):
+		event.Type = EventReady
+		event.Seconds, _ = strconv.ParseFloat(readyPattern.FindStringSubmatch(message)[1], 64)
+	case stoppingPattern.MatchString(message):
+		event.Type, event.Player = EventStopping, stoppingPattern.FindStringSubmatch(message)[1]
+	case lagPattern.MatchString(message):
+		m := lagPattern.FindStringSubmatch(message)
+		event.Type = EventLag
+		event.BehindMs, _ = strconv.Atoi(m[1])
+		event.BehindTicks, _ = strconv.Atoi(m[2])
+	case commandPattern.MatchString(message):
+		m := commandPattern.FindStringSubmatch(message)
+		event.Type, event.Player, event.Command = EventCommand, m[1], m[2]
+	case exceptionPattern.MatchString(message):
+		m := exceptionPattern.FindStringSubmatch(message)
+		event.Type, event.Exception, event.Message = EventException, m[1], m[2]
+	case deathPattern.MatchString(message):
+		event.Type, event.Player, event.Message = EventDeath, deathPattern.FindStringSubmatch(message)[1], message
+	default:
+		return nil
+	}
+
+	return event
+}
+
+func publishEvent(id string, event *ConsoleEvent) {
+	err := channels.BroadcastToChannel("server:events:"+id, channels.Message{
+		Type:   channels.TypeConsoleEvent,
+		Server: id,
+		Data:   event,
+	})
+	if err != nil {
+		zap.L().Error("failed to broadcast console event", zap.String("id", id), zap.Error(err))
+	}
+}
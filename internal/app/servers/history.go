@@ -0,0 +1,91 @@
+package servers
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"watercolormc/internal/app/audit"
+	"watercolormc/internal/app/channels"
+	"watercolormc/internal/database"
+)
+
+// where a command was sent from
+const (
+	CommandSourceConsole = "console"
+	CommandSourceAPI     = "api"
+)
+
+type CommandRecord struct {
+	Id         int64  `json:"id"`
+	ServerId   string `json:"serverId"`
+	Command    string `json:"command"`
+	Source     string `json:"source"`
+	Username   string `json:"username,omitempty"`
+	ClientAddr string `json:"clientAddr"`
+	UserAgent  string `json:"userAgent"`
+	ExecutedAt string `json:"executedAt"`
+}
+
+func recordCommand(id string, command string, source string, client channels.Client) {
+	db := database.Get()
+	if db == nil {
+		return
+	}
+
+	_, err := db.Client.Exec(`
+		INSERT INTO command_history (server_id, command, source, username, client_addr, user_agent)
+		VALUES (?, ?, ?, ?, ?, ?)`, id, command, source, client.Username, client.Addr, client.UserAgent)
+	if err != nil {
+		zap.L().Error("failed to record command", zap.String("id", id), zap.Error(err))
+	}
+
+	// commands sent through the API are audited with the request
+	if source == CommandSourceConsole {
+		audit.Record(audit.Entry{
+			Username:   client.Username,
+			ClientAddr: client.Addr,
+			UserAgent:  client.UserAgent,
+			ServerId:   id,
+			Action:     "console.command",
+			Params:     map[string]interface{}{"command": command, "source": source},
+			Result:     audit.ResultOk,
+		})
+	}
+}
+
+// GetCommandHistory returns up to limit commands sent to a server, newest first.
+// Pass the id of the oldest record already fetched as before to page further back,
+// or 0 to start from the newest.
+func GetCommandHistory(id string, before int64, limit int) ([]CommandRecord, error) {
+	db := database.Get()
+	if db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	query := `
+		SELECT id, server_id, command, source, username, client_addr, user_agent, executed_at
+		FROM command_history WHERE server_id = ?`
+	args := []interface{}{id}
+	if before > 0 {
+		query += ` AND id < ?`
+		args = append(args, before)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Client.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []CommandRecord{}
+	for rows.Next() {
+		var r CommandRecord
+		if err := rows.Scan(&r.Id, &r.ServerId, &r.Command, &r.Source, &r.Username, &r.ClientAddr, &r.UserAgent, &r.ExecutedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,56 @@
+package servers
+
+import (
+	"fmt"
+	"github.com/xDefyingGravity/gomcserver"
+	"os"
+	"os/exec"
+)
+
+// launcherArg marks a manager process started only to become a server's JVM
+const launcherArg = "--watercolormc-launch"
+
+// RunLauncher turns this process into a server's JVM when the manager started it as
+// a launcher, and returns otherwise. It must run before anything else in main.
+func RunLauncher() {
+	if len(os.Args) < 3 || os.Args[1] != launcherArg {
+		return
+	}
+
+	if err := execDetached(os.Args[2], os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to launch java:", err)
+		os.Exit(1)
+	}
+}
+
+// detach makes gomcserver start the JVM through the manager binary, which moves
+// itself into a session of its own and then replaces itself with java, keeping its
+// PID. Signals sent to the manager's process group, such as Ctrl+C in a terminal,
+// then no longer reach the servers, so they can be left running and reattached.
+func detach(opts *gomcserver.StartOptions) error {
+	if !detachSupported {
+		return nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	java := "java"
+	if opts.JavaPath != nil && *opts.JavaPath != "" {
+		java = *opts.JavaPath
+	}
+	javaPath, err := exec.LookPath(java)
+	if err != nil {
+		return fmt.Errorf("java executable not found: %w", err)
+	}
+
+	args := []string{launcherArg, javaPath}
+	if opts.JvmOptions != nil {
+		args = append(args, *opts.JvmOptions...)
+	}
+	opts.JavaPath = &self
+	opts.JvmOptions = &args
+	return nil
+}
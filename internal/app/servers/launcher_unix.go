@@ -0,0 +1,17 @@
+//go:build !windows
+
+package servers
+
+import (
+	"os"
+	"syscall"
+)
+
+const detachSupported = true
+
+func execDetached(path string, argv []string) error {
+	if _, err := syscall.Setsid(); err != nil {
+		return err
+	}
+	return syscall.Exec(path, argv, os.Environ())
+}
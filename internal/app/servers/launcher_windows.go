@@ -0,0 +1,12 @@
+//go:build windows
+
+package servers
+
+import "errors"
+
+// Windows has no process groups to leave, the JVM is started directly
+const detachSupported = false
+
+func execDetached(string, []string) error {
+	return errors.New("not supported on windows")
+}
@@ -0,0 +1,288 @@
+package servers
+
+import (
+	"errors"
+	"github.com/xDefyingGravity/gomcserver"
+	"go.uber.org/zap"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+	"watercolormc/internal/app/channels"
+	activeServers "watercolormc/internal/app/servers/active"
+)
+
+const (
+	DefaultStopTimeout = 60 * time.Second
+	killTimeout        = 10 * time.Second
+
+	exitCodeUnknown = -1
+)
+
+// stop reasons reported when a server goes offline
+const (
+	StopReasonRequested = "stop"
+	StopReasonTimeout   = "timeout"
+	StopReasonKilled    = "kill"
+	StopReasonExited    = "exit"
+	StopReasonCrashed   = "crash"
+	StopReasonFailed    = "failed"
+)
+
+type statsMessage struct {
+	Online   bool                    `json:"online"`
+	State    activeServers.State     `json:"state"`
+	Stats    *gomcserver.ServerStats `json:"stats,omitempty"`
+	ExitCode *int                    `json:"exitCode,omitempty"`
+	Reason   string                  `json:"reason,omitempty"`
+	Restart  *restartInfo            `json:"restart,omitempty"`
+	Ticks    *TickStats              `json:"ticks,omitempty"`
+}
+
+func broadcastStats(id string, message statsMessage) {
+	err := channels.BroadcastToChannel("server:stats:"+id, channels.Message{
+		Type:   channels.TypeServerStats,
+		Server: id,
+		Data:   message,
+	})
+	if err != nil {
+		zap.L().Error("failed to broadcast stats", zap.String("id", id), zap.Error(err))
+	}
+}
+
+// waitProcess reaps a server process started by this manager and reports its exit
+// code. Reattached servers are not our children, so their exit code is never known.
+func waitProcess(pid int, exit chan<- int) {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		exit <- exitCodeUnknown
+		return
+	}
+
+	state, err := process.Wait()
+	if err != nil {
+		zap.L().Error("failed to wait for server process", zap.Int("pid", pid), zap.Error(err))
+		exit <- exitCodeUnknown
+		return
+	}
+
+	code := state.ExitCode()
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		code = 128 + int(status.Signal())
+	}
+	exit <- code
+}
+
+// watchServer broadcasts process stats once per second until the server's process
+// exits, then marks the server offline.
+func watchServer(s activeServers.Server) {
+	time.Sleep(2 * time.Second)
+	for {
+		select {
+		case code := <-s.Exit:
+			handleExit(s.ID, code)
+			return
+		case <-time.After(1 * time.Second):
+		}
+
+		if s.PID <= 0 || !isRunning(s.PID) {
+			zap.L().Info("server is offline", zap.String("id", s.ID), zap.Int("pid", s.PID))
+			handleExit(s.ID, awaitExitCode(s))
+			return
+		}
+
+		stats, err := processStats(s.PID)
+		if err != nil {
+			zap.L().Error("failed to get stats", zap.Error(err))
+
+			if !isRunning(s.PID) {
+				zap.L().Info("server process not found, treating as offline", zap.String("id", s.ID))
+				handleExit(s.ID, awaitExitCode(s))
+				return
+			}
+
+			continue
+		}
+
+		stats.CPUPercent = stats.CPUPercent / float64(runtime.NumCPU())
+
+		current, ok := activeServers.Get(s.ID)
+		if !ok {
+			return
+		}
+		var ticks *TickStats
+		if current.State == activeServers.StateRunning {
+			ticks = currentTicks(s.ID)
+		}
+
+		broadcastStats(s.ID, statsMessage{Online: true, State: current.State, Stats: stats, Ticks: ticks})
+		recordStats(s.ID, stats, ticks)
+		setLatestSample(s.ID, stats, ticks)
+	}
+}
+
+func awaitExitCode(s activeServers.Server) int {
+	if s.Exit == nil {
+		return exitCodeUnknown
+	}
+
+	select {
+	case code := <-s.Exit:
+		return code
+	case <-time.After(1 * time.Second):
+		return exitCodeUnknown
+	}
+}
+
+// handleExit settles the final state of a server whose process is gone, announces
+// it on the stats channel and unregisters the server.
+func handleExit(id string, exitCode int) {
+	s, ok := activeServers.Get(id)
+	if !ok {
+		return
+	}
+
+	state := activeServers.StateStopped
+	reason := s.StopReason
+	switch s.State {
+	case activeServers.StateKilled:
+		state = activeServers.StateKilled
+	case activeServers.StateStarting, activeServers.StateRunning:
+		reason = StopReasonExited
+		if hasCrashed(s, exitCode) {
+			state = activeServers.StateCrashed
+			reason = StopReasonCrashed
+		}
+	}
+
+	zap.L().Info("server stopped",
+		zap.String("id", id),
+		zap.String("state", string(state)),
+		zap.String("reason", reason),
+		zap.Int("exitCode", exitCode),
+	)
+
+	message := statsMessage{Online: false, State: state, Reason: reason}
+	if exitCode != exitCodeUnknown {
+		message.ExitCode = &exitCode
+	}
+	message.Restart = planRestart(id, reason)
+	broadcastStats(id, message)
+
+	activeServers.Remove(id)
+	activeServers.SetLastState(id, state)
+	channels.RemoveListener("server:stdin:" + id)
+	stopTickMonitor(id)
+	clearLatestSample(id)
+	closeRcon(id)
+	removeProcessRecord(id)
+	close(s.Done)
+
+	if message.Restart != nil {
+		go performRestart(id, s.Name, reason, exitCode, message.Restart)
+	}
+}
+
+// markReady moves a starting server to running once it has finished loading.
+func markReady(id string) {
+	ready := false
+	activeServers.Update(id, func(srv *activeServers.Server) {
+		if srv.State == activeServers.StateStarting {
+			srv.State = activeServers.StateRunning
+			ready = true
+		}
+	})
+	if !ready {
+		return
+	}
+
+	zap.L().Info("server is ready", zap.String("id", id))
+	broadcastStats(id, statsMessage{Online: true, State: activeServers.StateRunning})
+}
+
+// markStopping records a stop typed into the console or issued in game, so the exit
+// that follows counts as requested rather than as the server going down on its own.
+func markStopping(id string) {
+	stopping := false
+	activeServers.Update(id, func(srv *activeServers.Server) {
+		if srv.State == activeServers.StateStarting || srv.State == activeServers.StateRunning {
+			srv.State = activeServers.StateStopping
+			srv.StopReason = StopReasonRequested
+			stopping = true
+		}
+	})
+	if !stopping {
+		return
+	}
+
+	zap.L().Info("server is stopping", zap.String("id", id))
+	broadcastStats(id, statsMessage{Online: true, State: activeServers.StateStopping})
+}
+
+func stopTimeout(id string) time.Duration {
+	config, err := LoadServerConfig(id)
+	if err != nil || config.StopTimeout <= 0 {
+		return DefaultStopTimeout
+	}
+	return time.Duration(config.StopTimeout) * time.Second
+}
+
+// enforceStopTimeout escalates a stop that did not finish within the grace period,
+// first with SIGTERM and then with SIGKILL.
+func enforceStopTimeout(s activeServers.Server, grace time.Duration) {
+	select {
+	case <-s.Done:
+		return
+	case <-time.After(grace):
+	}
+
+	zap.L().Warn("server did not stop in time, sending SIGTERM", zap.String("id", s.ID), zap.Duration("grace", grace))
+	activeServers.Update(s.ID, func(srv *activeServers.Server) {
+		srv.StopReason = StopReasonTimeout
+	})
+	if err := signalProcess(s.PID, syscall.SIGTERM); err != nil {
+		zap.L().Error("failed to send SIGTERM", zap.String("id", s.ID), zap.Error(err))
+	}
+
+	select {
+	case <-s.Done:
+		return
+	case <-time.After(killTimeout):
+	}
+
+	zap.L().Warn("server ignored SIGTERM, killing it", zap.String("id", s.ID))
+	activeServers.Update(s.ID, func(srv *activeServers.Server) {
+		srv.State = activeServers.StateKilled
+	})
+	if err := signalProcess(s.PID, syscall.SIGKILL); err != nil {
+		zap.L().Error("failed to kill server", zap.String("id", s.ID), zap.Error(err))
+	}
+}
+
+func signalProcess(pid int, sig syscall.Signal) error {
+	if pid <= 0 {
+		return errors.New("server process is not available")
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(sig)
+}
+
+// KillServer immediately kills a server's process without waiting for it to save.
+func KillServer(id string) error {
+	var pid int
+	ok := activeServers.Update(id, func(srv *activeServers.Server) {
+		srv.State = activeServers.StateKilled
+		srv.StopReason = StopReasonKilled
+		pid = srv.PID
+	})
+	if !ok {
+		return errors.New("server not found")
+	}
+
+	zap.L().Warn("killing server", zap.String("id", id), zap.Int("pid", pid))
+	return signalProcess(pid, syscall.SIGKILL)
+}
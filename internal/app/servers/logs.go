@@ -0,0 +1,190 @@
+package servers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"watercolormc/internal"
+	"watercolormc/internal/utils"
+)
+
+const maxLogLineSize = 1024 * 1024
+
+type LogFile struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	ModifiedAt string `json:"modifiedAt"`
+	Compressed bool   `json:"compressed"`
+}
+
+type LogPage struct {
+	Lines   []string `json:"lines"`
+	Offset  int      `json:"offset"`
+	Next    int      `json:"next"`
+	HasMore bool     `json:"hasMore"`
+}
+
+// rotated logs are named after the day they were started with a counter for the
+// day, e.g. 2024-01-10-2.log.gz
+var logArchivePattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-(\d+)\.log(?:\.gz)?$`)
+
+func isLogFileName(name string) bool {
+	return strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz")
+}
+
+// ListLogFiles lists the current and rotated log files of a server, latest.log
+// first and the archives newest first.
+func ListLogFiles(id string) ([]LogFile, error) {
+	serverFolder := utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + id)
+	if !utils.IsFileExists(serverFolder) {
+		return nil, errors.New("server not found")
+	}
+
+	logsFolder := filepath.Join(serverFolder, "logs")
+	if !utils.IsFileExists(logsFolder) {
+		return []LogFile{}, nil
+	}
+
+	entries, err := os.ReadDir(logsFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []LogFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isLogFileName(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, LogFile{
+			Name:       entry.Name(),
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime().Format(time.RFC3339),
+			Compressed: strings.HasSuffix(entry.Name(), ".gz"),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return logFileNewer(files[i].Name, files[j].Name)
+	})
+
+	return files, nil
+}
+
+// logFileNewer orders log files: latest.log, then the archives by day and counter,
+// newest first, then any other log files by name.
+func logFileNewer(a string, b string) bool {
+	if a == "latest.log" || b == "latest.log" {
+		return a == "latest.log" && b != "latest.log"
+	}
+
+	ma, mb := logArchivePattern.FindStringSubmatch(a), logArchivePattern.FindStringSubmatch(b)
+	switch {
+	case ma == nil && mb == nil:
+		return a > b
+	case ma == nil || mb == nil:
+		return mb == nil
+	case ma[1] != mb[1]:
+		return ma[1] > mb[1]
+	}
+
+	na, _ := strconv.Atoi(ma[2])
+	nb, _ := strconv.Atoi(mb[2])
+	return na > nb
+}
+
+// GetLogFilePath resolves a log file name to its path, refusing anything that is
+// not a log file directly inside the server's logs folder.
+func GetLogFilePath(id string, name string) (string, error) {
+	if name == "" || filepath.Base(name) != name || !isLogFileName(name) {
+		return "", errors.New("invalid log file name")
+	}
+
+	path := filepath.Join(utils.ExpandHome(internal.WatercolorDirectory+"/servers/"+id), "logs", name)
+	if !utils.IsFileExists(path) {
+		return "", errors.New("log file not found")
+	}
+
+	return path, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	_ = g.Reader.Close()
+	return g.file.Close()
+}
+
+// OpenLogFile opens a log file for reading, decompressing rotated archives.
+func OpenLogFile(id string, name string) (io.ReadCloser, error) {
+	path, err := GetLogFilePath(id, name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(name, ".gz") {
+		return file, nil
+	}
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &gzipFile{Reader: reader, file: file}, nil
+}
+
+// ReadLogLines returns up to limit lines of a log file starting at line offset.
+func ReadLogLines(id string, name string, offset int, limit int) (*LogPage, error) {
+	reader, err := OpenLogFile(id, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+
+	page := &LogPage{Lines: []string{}, Offset: offset}
+	line := 0
+	for scanner.Scan() {
+		if line < offset {
+			line++
+			continue
+		}
+		if len(page.Lines) == limit {
+			page.HasMore = true
+			break
+		}
+		page.Lines = append(page.Lines, scanner.Text())
+		line++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	page.Next = offset + len(page.Lines)
+	return page, nil
+}
@@ -0,0 +1,33 @@
+package servers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLogFileOrder(t *testing.T) {
+	names := []string{
+		"2024-01-10-2.log.gz",
+		"debug.log",
+		"2024-01-09-1.log.gz",
+		"latest.log",
+		"2024-01-10-10.log.gz",
+		"2024-01-10-1.log.gz",
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return logFileNewer(names[i], names[j])
+	})
+
+	want := []string{
+		"latest.log",
+		"2024-01-10-10.log.gz",
+		"2024-01-10-2.log.gz",
+		"2024-01-10-1.log.gz",
+		"2024-01-09-1.log.gz",
+		"debug.log",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+}
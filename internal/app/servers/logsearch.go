@@ -0,0 +1,273 @@
+package servers
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// [12:34:56] [Server thread/INFO]: message, as written to latest.log by vanilla and Paper
+	logLinePattern = regexp.MustCompile(`^\[(\d{2}:\d{2}:\d{2})\] \[([^\]]+)/([A-Z]+)\]: ?(.*)$`)
+	// [12:34:56 INFO]: message, as printed to the console by Paper
+	consoleLinePattern = regexp.MustCompile(`^\[(\d{2}:\d{2}:\d{2}) ([A-Z]+)\]: ?(.*)$`)
+	archiveNamePattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})-\d+\.log(\.gz)?$`)
+)
+
+type LogRecord struct {
+	File    string     `json:"file"`
+	Line    int        `json:"line"`
+	Time    *time.Time `json:"time,omitempty"`
+	Thread  string     `json:"thread,omitempty"`
+	Level   string     `json:"level,omitempty"`
+	Message string     `json:"message"`
+}
+
+type LogMatch struct {
+	LogRecord
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+type LogQuery struct {
+	From     *time.Time
+	To       *time.Time
+	Levels   []string
+	Thread   string
+	Contains string
+	Regex    *regexp.Regexp
+	Context  int
+	Limit    int
+}
+
+// ParseLogLine splits a log line into its clock time, thread, level and message.
+// ok is false for lines without a prefix, such as stack trace continuations.
+func ParseLogLine(line string) (clock string, thread string, level string, message string, ok bool) {
+	if m := logLinePattern.FindStringSubmatch(line); m != nil {
+		return m[1], m[2], m[3], m[4], true
+	}
+	if m := consoleLinePattern.FindStringSubmatch(line); m != nil {
+		return m[1], "", m[2], m[3], true
+	}
+	return "", "", "", line, false
+}
+
+func (q *LogQuery) matches(record *LogRecord) bool {
+	if record.Time != nil {
+		if q.From != nil && record.Time.Before(*q.From) {
+			return false
+		}
+		if q.To != nil && record.Time.After(*q.To) {
+			return false
+		}
+	} else if q.From != nil || q.To != nil {
+		return false
+	}
+
+	if len(q.Levels) > 0 {
+		found := false
+		for _, level := range q.Levels {
+			if strings.EqualFold(level, record.Level) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if q.Thread != "" && !strings.Contains(strings.ToLower(record.Thread), strings.ToLower(q.Thread)) {
+		return false
+	}
+	if q.Contains != "" && !strings.Contains(strings.ToLower(record.Message), strings.ToLower(q.Contains)) {
+		return false
+	}
+	if q.Regex != nil && !q.Regex.MatchString(record.Message) {
+		return false
+	}
+
+	return true
+}
+
+// logStartDate works out the date the first line of a log file was written on.
+// Archives carry it in their name; for latest.log it is derived from the file's
+// modification time minus every midnight the log has crossed since.
+func logStartDate(id string, name string) (time.Time, error) {
+	if m := archiveNamePattern.FindStringSubmatch(name); m != nil {
+		return time.ParseInLocation("2006-01-02", m[1], time.Local)
+	}
+
+	path, err := GetLogFilePath(id, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	reader, err := OpenLogFile(id, name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+
+	wraps := 0
+	previous := ""
+	for scanner.Scan() {
+		clock, _, _, _, ok := ParseLogLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		if clock < previous {
+			wraps++
+		}
+		previous = clock
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	modified := info.ModTime()
+	day := time.Date(modified.Year(), modified.Month(), modified.Day(), 0, 0, 0, 0, time.Local)
+	return day.AddDate(0, 0, -wraps), nil
+}
+
+// SearchLogs scans latest.log and the rotated archives of a server, newest file
+// first, and hands every record matching the query to emit as soon as its context
+// is complete. It stops after q.Limit matches or when emit returns an error.
+func SearchLogs(id string, q LogQuery, emit func(LogMatch) error) error {
+	files, err := ListLogFiles(id)
+	if err != nil {
+		return err
+	}
+
+	found := 0
+	for _, file := range files {
+		startDate, err := logStartDate(id, file.Name)
+		if err != nil {
+			return err
+		}
+		if q.To != nil && startDate.After(*q.To) {
+			continue
+		}
+
+		n, err := searchLogFile(id, file.Name, startDate, q, q.Limit-found, emit)
+		found += n
+		if err != nil {
+			return err
+		}
+		if q.Limit > 0 && found >= q.Limit {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+var errSearchLimit = errors.New("search limit reached")
+
+func searchLogFile(id string, name string, date time.Time, q LogQuery, limit int, emit func(LogMatch) error) (int, error) {
+	reader, err := OpenLogFile(id, name)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+
+	var (
+		found    int
+		before   []string
+		pending  []*LogMatch
+		previous string
+		current  LogRecord
+	)
+
+	flush := func(match *LogMatch) error {
+		if q.Limit > 0 && found >= limit {
+			return errSearchLimit
+		}
+		found++
+		return emit(*match)
+	}
+
+	lineNumber := 0
+	for scanner.Scan() {
+		raw := scanner.Text()
+		lineNumber++
+
+		for i := 0; i < len(pending); i++ {
+			pending[i].After = append(pending[i].After, raw)
+			if len(pending[i].After) >= q.Context {
+				if err := flush(pending[i]); err != nil {
+					return found, ignoreLimit(err)
+				}
+				pending = append(pending[:i], pending[i+1:]...)
+				i--
+			}
+		}
+
+		clock, thread, level, message, ok := ParseLogLine(raw)
+		if ok {
+			if clock < previous {
+				date = date.AddDate(0, 0, 1)
+			}
+			previous = clock
+
+			current = LogRecord{Thread: thread, Level: level}
+			if t, err := time.ParseInLocation("15:04:05", clock, time.Local); err == nil {
+				ts := time.Date(date.Year(), date.Month(), date.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+				current.Time = &ts
+			}
+		}
+		// lines without a prefix continue the previous record, e.g. stack traces
+		record := current
+		record.File = name
+		record.Line = lineNumber
+		record.Message = message
+
+		if q.matches(&record) {
+			match := &LogMatch{LogRecord: record}
+			if q.Context > 0 {
+				match.Before = append([]string(nil), before...)
+				pending = append(pending, match)
+			} else if err := flush(match); err != nil {
+				return found, ignoreLimit(err)
+			}
+		}
+
+		if q.Context > 0 {
+			before = append(before, raw)
+			if len(before) > q.Context {
+				before = before[1:]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return found, err
+	}
+
+	for _, match := range pending {
+		if err := flush(match); err != nil {
+			return found, ignoreLimit(err)
+		}
+	}
+
+	return found, nil
+}
+
+func ignoreLimit(err error) error {
+	if errors.Is(err, errSearchLimit) {
+		return nil
+	}
+	return err
+}
@@ -2,11 +2,7 @@ package servers
 
 import (
 	"archive/zip"
-	"bytes"
-	"database/sql"
-	"encoding/json"
 	"errors"
-	"github.com/gofiber/websocket/v2"
 	"github.com/magiconair/properties"
 	"github.com/vmihailenco/msgpack/v5"
 	"github.com/xDefyingGravity/gomcserver"
@@ -15,7 +11,6 @@ import (
 	"mime/multipart"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -23,7 +18,7 @@ import (
 	"watercolormc/internal"
 	"watercolormc/internal/app/channels"
 	activeServers "watercolormc/internal/app/servers/active"
-	"watercolormc/internal/database"
+	"watercolormc/internal/app/servers/console"
 	"watercolormc/internal/utils"
 )
 
@@ -54,8 +49,13 @@ type JavaSettings struct {
 }
 
 type ServerConfig struct {
-	Versions     Versions     `msgpack:"versions"`
-	JavaSettings JavaSettings `msgpack:"javaSettings"`
+	Versions      Versions      `msgpack:"versions"`
+	JavaSettings  JavaSettings  `msgpack:"javaSettings"`
+	StopTimeout   int           `msgpack:"stopTimeout"` // seconds to wait for a clean stop before killing
+	RestartPolicy RestartPolicy `msgpack:"restartPolicy"`
+	Rcon          RconSettings  `msgpack:"rcon"`
+
+	ConsoleBufferLines int `msgpack:"consoleBufferLines"`
 }
 
 func LoadServerConfig(id string) (*ServerConfig, error) {
@@ -115,20 +115,100 @@ func CreateDefaultServerConfig(minecraftVersion string) *ServerConfig {
 			JavaPath: "",
 			JvmArgs:  []string{},
 		},
+		StopTimeout:   int(DefaultStopTimeout.Seconds()),
+		RestartPolicy: DefaultRestartPolicy(),
+
+		ConsoleBufferLines: console.DefaultSize,
 	}
 }
 
-func makeLogListener(channel, id string) func(string) {
+func makeLogListener(channel string, buf *console.Buffer, id string) func(string) {
 	idCopy := id
 	return func(msg string) {
-		err := channels.BroadcastToChannel("server:"+channel+":"+idCopy, websocket.TextMessage, []byte(msg))
-		zap.L().Debug("broadcasted "+channel, zap.String("id", idCopy), zap.String("message", msg))
-		if err != nil {
-			zap.L().Error("failed to broadcast "+channel, zap.Error(err))
+		for _, line := range buf.Write(channel, msg) {
+			err := channels.BroadcastToChannel("server:"+channel+":"+idCopy, consoleMessage(idCopy, line))
+			zap.L().Debug("broadcasted "+channel, zap.String("id", idCopy), zap.String("message", line.Text))
+			if err != nil {
+				zap.L().Error("failed to broadcast "+channel, zap.Error(err))
+			}
+
+			if channel == "stdout" {
+				if event := ParseConsoleEvent(line.Text); event != nil {
+					event.Time = line.Time
+					publishEvent(idCopy, event)
+
+					switch event.Type {
+					case EventReady:
+						markReady(idCopy)
+					case EventStopping:
+						markStopping(idCopy)
+					case EventLag:
+						recordLag(idCopy, event.BehindMs)
+					case EventJoin, EventLeave:
+						// gomcserver keeps the player list from its own stdout, which a
+						// reattached server no longer has
+						activeServers.Update(idCopy, func(srv *activeServers.Server) {
+							if srv.Reattached {
+								srv.Instance.Players = trackPlayer(srv.Instance.Players, event)
+							}
+						})
+					}
+				}
+			}
 		}
 	}
 }
 
+func consoleMessage(id string, line console.Line) channels.Message {
+	return channels.Message{
+		Type:   channels.TypeConsoleLine,
+		Server: id,
+		Data:   line,
+		Raw:    []byte(line.Text + "\n"),
+	}
+}
+
+// openConsole returns the console buffer of a server, sized from its config, and
+// makes new subscribers of its stdout and stderr channels receive the buffered lines.
+func openConsole(id string) *console.Buffer {
+	size := console.DefaultSize
+	if config, err := LoadServerConfig(id); err == nil && config.ConsoleBufferLines > 0 {
+		size = config.ConsoleBufferLines
+	}
+
+	buf := console.Get(id, size)
+	for _, stream := range []string{"stdout", "stderr"} {
+		stream := stream
+		channels.SetReplayer("server:"+stream+":"+id, func() []channels.Message {
+			var msgs []channels.Message
+			for _, line := range buf.Since(0) {
+				if line.Stream == stream {
+					msgs = append(msgs, consoleMessage(id, line))
+				}
+			}
+			return msgs
+		})
+	}
+
+	return buf
+}
+
+// DeleteConsole drops the console buffer of a server that no longer exists.
+func DeleteConsole(id string) {
+	console.Remove(id)
+	channels.RemoveReplayer("server:stdout:" + id)
+	channels.RemoveReplayer("server:stderr:" + id)
+}
+
+// GetConsole returns the buffered console lines of a server newer than since.
+func GetConsole(id string, since uint64) []console.Line {
+	buf, ok := console.Lookup(id)
+	if !ok {
+		return []console.Line{}
+	}
+	return buf.Since(since)
+}
+
 func isRunning(pid int) bool {
 	process, err := os.FindProcess(pid)
 	if err != nil {
@@ -144,82 +224,71 @@ func secureClone(s string) string {
 }
 
 func StartServer(id string) error {
-	db := database.Get()
-	if db == nil {
-		return errors.New("database not initialized")
+	if shuttingDown.Load() {
+		return errors.New("manager is shutting down")
 	}
 
-	var (
-		name, host, version, createdAt string
-		port                           int
-	)
+	if activeServers.IsOnline(id) {
+		return errors.New("server is already running")
+	}
 
-	query := `
-		SELECT name, port, host, version, created_at
-		FROM servers WHERE id = ?`
-	err := db.Client.QueryRow(query, id).Scan(&name, &port, &host, &version, &createdAt)
+	record, err := Repository().Get(id)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("server not found")
-		}
 		return err
 	}
 
-	stdoutBuf, stderrBuf := bytes.Buffer{}, bytes.Buffer{}
-	server := gomcserver.NewServer(name, version)
+	if err := checkPort(id, record.Host, record.Port, false); err != nil {
+		return err
+	}
+
+	server := gomcserver.NewServer(record.Name, record.Version)
 	server.Directory = utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + id)
-	server.SetProperty("server-port", strconv.Itoa(port))
-	server.SetProperty("server-ip", host)
+	server.SetProperty("server-port", strconv.Itoa(record.Port))
+	server.SetProperty("server-ip", record.Host)
 
 	s := activeServers.Server{
-		ID:           secureClone(id),
-		Name:         name,
-		Port:         port,
-		Host:         host,
-		Version:      version,
-		CreatedAt:    createdAt,
-		StdoutBuffer: &stdoutBuf,
-		StderrBuffer: &stderrBuf,
-		StdoutWriter: &stdoutBuf,
-		StderrWriter: &stderrBuf,
-		Instance:     server,
+		ID:        secureClone(id),
+		Name:      record.Name,
+		Port:      record.Port,
+		Host:      record.Host,
+		Version:   record.Version,
+		CreatedAt: record.CreatedAt,
+		Console:   openConsole(id),
+		Done:      make(chan struct{}),
+		Exit:      make(chan int, 1),
+		State:     activeServers.StateInstalling,
+		Instance:  server,
 	}
 	activeServers.Add(s)
+	broadcastStats(id, statsMessage{Online: false, State: activeServers.StateInstalling})
+
+	// a start that fails must still close Done, StopAll waits on it
+	started := false
+	defer func() {
+		if !started {
+			activeServers.Remove(id)
+			activeServers.SetLastState(id, activeServers.StateFailed)
+			channels.RemoveListener("server:stdin:" + id)
+			broadcastStats(id, statsMessage{Online: false, State: activeServers.StateFailed, Reason: StopReasonFailed})
+			close(s.Done)
+		}
+	}()
 
-	zap.L().Info("starting server", zap.String("id", id), zap.String("name", name), zap.Int("port", port))
+	zap.L().Info("starting server", zap.String("id", id), zap.String("name", record.Name), zap.Int("port", record.Port))
 	zap.L().Info("setting stdout listener", zap.String("id", id))
 
-	if err := server.SetEventListener("stdout", makeLogListener("stdout", s.ID)); err != nil {
+	if err := server.SetEventListener("stdout", makeLogListener("stdout", s.Console, s.ID)); err != nil {
 		zap.L().Error("failed to set stdout listener", zap.Error(err))
 		return err
 	}
-	if err := server.SetEventListener("stderr", makeLogListener("stderr", s.ID)); err != nil {
+	if err := server.SetEventListener("stderr", makeLogListener("stderr", s.Console, s.ID)); err != nil {
 		zap.L().Error("failed to set stderr listener", zap.Error(err))
 		return err
 	}
 
 	server.AcceptEULA()
 
-	if err := channels.BroadcastToChannel("server:stdout:"+id, websocket.TextMessage, []byte{}); err != nil {
-		return err
-	}
-	if err := channels.BroadcastToChannel("server:stderr:"+id, websocket.TextMessage, []byte{}); err != nil {
-		return err
-	}
-
-	if err := channels.BroadcastToChannel("server:stats:"+id, websocket.TextMessage, []byte{}); err != nil {
-		return err
-	}
-
-	if err := channels.BroadcastToChannel("server:stdin:"+id, websocket.TextMessage, []byte{}); err != nil {
-		return err
-	}
-
-	if err := channels.BroadcastToChannel("server:players:"+id, websocket.TextMessage, []byte{}); err != nil {
-		return err
-	}
-
-	channels.SetListener("server:stdin:"+id, func(msg string) error {
+	channels.SetListener("server:stdin:"+id, func(msg string, client channels.Client) error {
 		if server.GetPID() == -1 {
 			return errors.New("server is not running")
 		}
@@ -227,13 +296,19 @@ func StartServer(id string) error {
 			zap.L().Error("failed to send command to server", zap.Error(err))
 			return err
 		}
+		recordCommand(s.ID, msg, CommandSourceConsole, client)
 		return nil
 	})
 
 	err = server.SetEventListener("playerJoin", func(playerName string, _ int) {
 		zap.L().Info("player joined", zap.String("id", s.ID), zap.String("player", playerName))
 
-		err := channels.BroadcastToChannel("server:players:"+s.ID, websocket.TextMessage, []byte("join:"+playerName))
+		err := channels.BroadcastToChannel("server:players:"+s.ID, channels.Message{
+			Type:   channels.TypePlayerJoin,
+			Server: s.ID,
+			Data:   map[string]string{"player": playerName},
+			Raw:    []byte("join:" + playerName),
+		})
 		if err != nil {
 			zap.L().Error("failed to broadcast player join", zap.Error(err))
 		}
@@ -252,7 +327,12 @@ func StartServer(id string) error {
 	err = server.SetEventListener("playerLeave", func(playerName string, _ int) {
 		zap.L().Info("player left", zap.String("id", s.ID), zap.String("player", playerName))
 
-		err := channels.BroadcastToChannel("server:players:"+s.ID, websocket.TextMessage, []byte("leave:"+playerName))
+		err := channels.BroadcastToChannel("server:players:"+s.ID, channels.Message{
+			Type:   channels.TypePlayerLeave,
+			Server: s.ID,
+			Data:   map[string]string{"player": playerName},
+			Raw:    []byte("leave:" + playerName),
+		})
 		if err != nil {
 			zap.L().Error("failed to broadcast player leave", zap.Error(err))
 		}
@@ -268,83 +348,20 @@ func StartServer(id string) error {
 		return err
 	}
 
-	go func() {
-		time.Sleep(2 * time.Second)
-		for {
-			time.Sleep(1 * time.Second)
-
-			if !isRunning(server.GetPID()) || server.GetPID() == -1 {
-				zap.L().Info("server is offline", zap.String("id", s.ID), zap.Int("pid", server.GetPID()))
-				msg, err := json.Marshal(struct {
-					Online bool `json:"online"`
-				}{Online: false})
-				if err != nil {
-					zap.L().Error("failed to marshal offline message", zap.Error(err))
-					continue
-				}
-
-				err = channels.BroadcastToChannel("server:stats:"+s.ID, websocket.TextMessage, msg)
-				if err != nil {
-					zap.L().Error("failed to broadcast offline stats", zap.Error(err))
-					continue
-				}
-
-				activeServers.Remove(s.ID)
-				channels.RemoveListener("server:stdin:" + id)
-				break
-			}
-
-			stats, err := server.GetStats()
-			if err != nil {
-				zap.L().Error("failed to get stats", zap.Error(err))
-
-				if strings.Contains(err.Error(), "no such process") {
-					zap.L().Info("server process not found, treating as offline", zap.String("id", s.ID))
-
-					msg, err := json.Marshal(struct {
-						Online bool `json:"online"`
-					}{Online: false})
-					if err != nil {
-						zap.L().Error("failed to marshal offline message", zap.Error(err))
-						continue
-					}
-
-					err = channels.BroadcastToChannel("server:stats:"+s.ID, websocket.TextMessage, msg)
-					if err != nil {
-						zap.L().Error("failed to broadcast offline stats", zap.Error(err))
-						continue
-					}
-
-					activeServers.Remove(s.ID)
-					channels.RemoveListener("server:stdin:" + id)
-					break
-				}
-
-				continue
-			}
-
-			stats.CPUPercent = stats.CPUPercent / float64(runtime.NumCPU())
-
-			msg, err := json.Marshal(struct {
-				Online bool                    `json:"online"`
-				Stats  *gomcserver.ServerStats `json:"stats"`
-			}{Online: true, Stats: stats})
-
-			if err != nil {
-				zap.L().Error("failed to marshal stats message", zap.Error(err))
-				continue
-			}
-
-			_ = channels.BroadcastToChannel("server:stats:"+s.ID, websocket.TextMessage, msg)
-		}
-	}()
-
 	config, err := LoadServerConfig(id)
 	if err != nil {
 		zap.L().Error("failed to load server config", zap.Error(err))
 		return err
 	}
 
+	if err := ensureRconSettings(*record, config); err != nil {
+		zap.L().Error("failed to configure rcon", zap.Error(err))
+		return err
+	}
+	for key, value := range rconProperties(config.Rcon) {
+		server.SetProperty(key, value)
+	}
+
 	err = server.SetMinMemoryMB(config.JavaSettings.Memory.Min)
 	if err != nil {
 		return err
@@ -356,8 +373,6 @@ func StartServer(id string) error {
 	}
 
 	startOpts := &gomcserver.StartOptions{
-		StdoutPipe:       &stdoutBuf,
-		StderrPipe:       &stderrBuf,
 		UseManifestCache: utils.PtrBool(true),
 		CacheDir:         utils.PtrString(utils.ExpandHome(internal.WatercolorDirectory + "/cache")),
 		JvmOptions:       &config.JavaSettings.JvmArgs,
@@ -367,24 +382,72 @@ func StartServer(id string) error {
 		startOpts.JavaPath = &config.JavaSettings.JavaPath
 	}
 
-	if err := server.Start(startOpts); err != nil {
-		activeServers.Remove(id)
+	if err := detach(startOpts); err != nil {
+		return err
+	}
+
+	// StopAll does not stop servers that are still installing, they give up here
+	if shuttingDown.Load() {
+		return errors.New("manager is shutting down")
+	}
+
+	err = server.Start(startOpts)
+	reclaimSignals()
+	if err != nil {
 		return err
 	}
+	started = true
+
+	s.PID = server.GetPID()
+	s.StartedAt = time.Now()
+	s.State = activeServers.StateStarting
+	activeServers.Add(s)
+	startTickMonitor(s)
+	broadcastStats(id, statsMessage{Online: true, State: activeServers.StateStarting})
+
+	go waitProcess(s.PID, s.Exit)
+
+	if err := recordProcess(s); err != nil {
+		zap.L().Error("failed to record server process", zap.String("id", id), zap.Error(err))
+	}
+
+	go watchServer(s)
 
 	return nil
 }
 
+// StopServer asks a server to shut down cleanly. The server stays active in the
+// stopping state until its process exits; if that takes longer than the configured
+// grace period it is terminated and then killed.
 func StopServer(id string) error {
 	server, ok := activeServers.Get(id)
 	if !ok {
 		return errors.New("server not found")
 	}
+	switch server.State {
+	case activeServers.StateRunning, activeServers.StateStarting:
+	case activeServers.StateInstalling:
+		return errors.New("server is still installing")
+	default:
+		return errors.New("server is already " + string(server.State))
+	}
+
+	activeServers.Update(id, func(srv *activeServers.Server) {
+		srv.State = activeServers.StateStopping
+		srv.StopReason = StopReasonRequested
+	})
+	broadcastStats(id, statsMessage{Online: true, State: activeServers.StateStopping})
+
 	if err := server.Instance.SendCommand("stop"); err != nil {
-		return err
+		if _, rconErr := rconCommand(id, "stop"); rconErr != nil {
+			zap.L().Warn("failed to send stop command, sending SIGTERM instead", zap.String("id", id), zap.Error(err), zap.NamedError("rconError", rconErr))
+			if err := signalProcess(server.PID, syscall.SIGTERM); err != nil {
+				return err
+			}
+		}
 	}
-	activeServers.Remove(id)
-	channels.RemoveListener("server:stdin:" + id)
+
+	go enforceStopTimeout(server, stopTimeout(id))
 	return nil
 }
 
@@ -581,11 +644,15 @@ func InitServer(server Server) error {
 	}
 
 	config := CreateDefaultServerConfig(server.Version)
+	config.Rcon, err = newRconSettings(server)
+	if err != nil {
+		return err
+	}
 	if err := SaveServerConfig(server.Id, config); err != nil {
 		return err
 	}
 
-	return nil
+	return writeRconProperties(directory, config.Rcon)
 }
 
 func GetServerPlayers(id string) ([]string, error) {
@@ -598,33 +665,17 @@ func GetServerPlayers(id string) ([]string, error) {
 }
 
 func BackupServer(id string) error {
-	db := database.Get()
-	if db == nil {
-		return errors.New("database not initialized")
-	}
-
 	serverFolder := utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + id)
 	if !utils.IsFileExists(serverFolder) {
 		return errors.New("server folder not found")
 	}
 
-	var (
-		name, host, version, createdAt string
-		port                           int
-	)
-
-	query := `
-		SELECT name, port, host, version, created_at
-		FROM servers WHERE id = ?`
-	err := db.Client.QueryRow(query, id).Scan(&name, &port, &host, &version, &createdAt)
+	record, err := Repository().Get(id)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("server not found")
-		}
 		return err
 	}
 
-	server := gomcserver.NewServer(name, version)
+	server := gomcserver.NewServer(record.Name, record.Version)
 	server.Directory = serverFolder
 
 	err = server.SendCommand("save-off")
@@ -647,33 +698,17 @@ func BackupServer(id string) error {
 }
 
 func RestoreBackup(serverId string, backup string) error {
-	db := database.Get()
-	if db == nil {
-		return errors.New("database not initialized")
-	}
-
 	serverFolder := utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + serverId)
 	if !utils.IsFileExists(serverFolder) {
 		return errors.New("server folder not found")
 	}
 
-	var (
-		name, host, version, createdAt string
-		port                           int
-	)
-
-	query := `
-		SELECT name, port, host, version, created_at
-		FROM servers WHERE id = ?`
-	err := db.Client.QueryRow(query, serverId).Scan(&name, &port, &host, &version, &createdAt)
+	record, err := Repository().Get(serverId)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return errors.New("server not found")
-		}
 		return err
 	}
 
-	server := gomcserver.NewServer(name, version)
+	server := gomcserver.NewServer(record.Name, record.Version)
 	server.Directory = serverFolder
 
 	err = server.RestoreBackup(backup)
@@ -0,0 +1,189 @@
+package servers
+
+import (
+	"github.com/xDefyingGravity/gomcserver"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"watercolormc/internal"
+	activeServers "watercolormc/internal/app/servers/active"
+	"watercolormc/internal/database"
+	"watercolormc/internal/metrics"
+	"watercolormc/internal/utils"
+)
+
+type latestSample struct {
+	Stats *gomcserver.ServerStats
+	Ticks *TickStats
+}
+
+var (
+	samplesMu     sync.RWMutex
+	latestSamples = make(map[string]latestSample)
+)
+
+func setLatestSample(id string, stats *gomcserver.ServerStats, ticks *TickStats) {
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+
+	latestSamples[id] = latestSample{Stats: stats, Ticks: ticks}
+}
+
+func clearLatestSample(id string) {
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+
+	delete(latestSamples, id)
+}
+
+// CollectMetrics reports the state and resource usage of every server.
+func CollectMetrics(e *metrics.Encoder) {
+	db := database.Get()
+	if db == nil {
+		return
+	}
+
+	type serverInfo struct{ id, name string }
+
+	records, err := Repository().List()
+	if err != nil {
+		zap.L().Error("failed to list servers for metrics", zap.Error(err))
+		return
+	}
+	servers := make([]serverInfo, 0, len(records))
+	for _, record := range records {
+		servers = append(servers, serverInfo{id: record.Id, name: record.Name})
+	}
+
+	restarts := make(map[string]int)
+	rows, err := db.Client.Query(`SELECT server_id, COUNT(*) FROM server_restarts GROUP BY server_id`)
+	if err != nil {
+		zap.L().Error("failed to count restarts for metrics", zap.Error(err))
+	} else {
+		for rows.Next() {
+			var (
+				id    string
+				count int
+			)
+			if err := rows.Scan(&id, &count); err == nil {
+				restarts[id] = count
+			}
+		}
+		_ = rows.Close()
+	}
+
+	samplesMu.RLock()
+	samples := make(map[string]latestSample, len(latestSamples))
+	for id, sample := range latestSamples {
+		samples[id] = sample
+	}
+	samplesMu.RUnlock()
+
+	type gauge struct {
+		name, kind, help string
+		value            func(info serverInfo, s activeServers.Server, active bool) (float64, bool)
+	}
+
+	gauges := []gauge{
+		{"watercolormc_server_online", "gauge", "Whether the server process is running.",
+			func(_ serverInfo, _ activeServers.Server, active bool) (float64, bool) {
+				if active {
+					return 1, true
+				}
+				return 0, true
+			}},
+		{"watercolormc_server_uptime_seconds", "gauge", "Time since the server process was started.",
+			func(_ serverInfo, s activeServers.Server, active bool) (float64, bool) {
+				if !active || s.StartedAt.IsZero() {
+					return 0, false
+				}
+				return time.Since(s.StartedAt).Seconds(), true
+			}},
+		{"watercolormc_server_cpu_percent", "gauge", "CPU usage of the server process, normalised to all cores.",
+			func(info serverInfo, _ activeServers.Server, _ bool) (float64, bool) {
+				sample, ok := samples[info.id]
+				if !ok || sample.Stats == nil {
+					return 0, false
+				}
+				return sample.Stats.CPUPercent, true
+			}},
+		{"watercolormc_server_memory_rss_bytes", "gauge", "Resident memory of the server process.",
+			func(info serverInfo, _ activeServers.Server, _ bool) (float64, bool) {
+				sample, ok := samples[info.id]
+				if !ok || sample.Stats == nil {
+					return 0, false
+				}
+				return sample.Stats.MemoryMB * 1024 * 1024, true
+			}},
+		{"watercolormc_server_players", "gauge", "Players currently online.",
+			func(_ serverInfo, s activeServers.Server, active bool) (float64, bool) {
+				if !active || s.Instance == nil {
+					return 0, false
+				}
+				return float64(len(s.Instance.Players)), true
+			}},
+		{"watercolormc_server_tps", "gauge", "Ticks per second over the last minute.",
+			func(info serverInfo, _ activeServers.Server, _ bool) (float64, bool) {
+				sample, ok := samples[info.id]
+				if !ok || sample.Ticks == nil {
+					return 0, false
+				}
+				return sample.Ticks.TPS, true
+			}},
+		{"watercolormc_server_mspt", "gauge", "Average milliseconds per tick, only reported by Paper servers.",
+			func(info serverInfo, _ activeServers.Server, _ bool) (float64, bool) {
+				sample, ok := samples[info.id]
+				if !ok || sample.Ticks == nil || sample.Ticks.MSPT == nil {
+					return 0, false
+				}
+				return *sample.Ticks.MSPT, true
+			}},
+		{"watercolormc_server_restarts_total", "counter", "Automatic restarts performed by the restart policy.",
+			func(info serverInfo, _ activeServers.Server, _ bool) (float64, bool) {
+				return float64(restarts[info.id]), true
+			}},
+		{"watercolormc_server_backup_age_seconds", "gauge", "Time since the newest backup was written.",
+			func(info serverInfo, _ activeServers.Server, _ bool) (float64, bool) {
+				newest, ok := newestBackupTime(info.id)
+				if !ok {
+					return 0, false
+				}
+				return time.Since(newest).Seconds(), true
+			}},
+	}
+
+	for _, g := range gauges {
+		e.Header(g.name, g.kind, g.help)
+		for _, info := range servers {
+			s, active := activeServers.Get(info.id)
+			if value, ok := g.value(info, s, active); ok {
+				e.Sample(g.name, value, "id", info.id, "name", info.name)
+			}
+		}
+	}
+}
+
+func newestBackupTime(id string) (time.Time, bool) {
+	backupDir := filepath.Join(utils.ExpandHome(internal.WatercolorDirectory+"/servers/"+id), "backups")
+	entries, err := os.ReadDir(backupDir)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var newest time.Time
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, !newest.IsZero()
+}
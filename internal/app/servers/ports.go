@@ -0,0 +1,214 @@
+package servers
+
+import (
+	"errors"
+	"fmt"
+	gnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+	"watercolormc/internal"
+	activeServers "watercolormc/internal/app/servers/active"
+)
+
+var (
+	ErrPortInUse  = errors.New("port in use")
+	ErrNoFreePort = errors.New("no free port in the configured range")
+)
+
+// PortConflict is returned when a port is already taken, either by another
+// registered server or by some other process on the host. Rcon is set when the
+// port in question is an RCON port, the owner's or the one the server would get.
+// Handlers send it as the body of a 409. It matches ErrPortInUse.
+type PortConflict struct {
+	Port       int    `json:"port"`
+	Rcon       bool   `json:"rcon,omitempty"`
+	ServerId   string `json:"serverId,omitempty"`
+	ServerName string `json:"serverName,omitempty"`
+	Pid        int32  `json:"pid,omitempty"`
+	Process    string `json:"process,omitempty"`
+}
+
+func (c *PortConflict) Error() string {
+	kind := "port"
+	if c.Rcon {
+		kind = "rcon port"
+	}
+
+	switch {
+	case c.ServerId != "" && c.ServerName != c.ServerId:
+		return fmt.Sprintf("%s %d is used by server %s (%s)", kind, c.Port, c.ServerId, c.ServerName)
+	case c.ServerId != "":
+		return fmt.Sprintf("%s %d is used by server %s", kind, c.Port, c.ServerId)
+	case c.Pid != 0 && c.Process != "":
+		return fmt.Sprintf("%s %d is used by %s (pid %d)", kind, c.Port, c.Process, c.Pid)
+	case c.Pid != 0:
+		return fmt.Sprintf("%s %d is used by pid %d", kind, c.Port, c.Pid)
+	default:
+		return fmt.Sprintf("%s %d is used by another process", kind, c.Port)
+	}
+}
+
+func (c *PortConflict) Is(target error) bool {
+	return target == ErrPortInUse
+}
+
+// CheckPort makes sure a server can have a port: no other registered server may
+// claim it as its game or RCON port, nor the server's own RCON port, and nothing on
+// the host may be listening on it. This is the same rule SuggestPort follows.
+func CheckPort(id string, host string, port int) error {
+	return checkPort(id, host, port, true)
+}
+
+// checkPort looks for the owner of a port. Stopped servers claiming the port only
+// count when registered is set; at start time only a running one is in the way, and
+// a taken RCON port is moved by ensureRconSettings instead.
+func checkPort(id string, host string, port int, registered bool) error {
+	claimed, err := claimedPorts(id, !registered)
+	if err != nil {
+		return err
+	}
+	if claim, ok := claimed[port]; ok {
+		return claim
+	}
+
+	if registered {
+		rconPort := port + rconPortOffset
+		if config, err := LoadServerConfig(id); err == nil && config.Rcon.Port != 0 {
+			rconPort = config.Rcon.Port
+		}
+		if rconPort == port {
+			conflict := &PortConflict{Port: port, Rcon: true, ServerId: id, ServerName: id}
+			if self, err := Repository().Get(id); err == nil {
+				conflict.ServerName = self.Name
+			}
+			return conflict
+		}
+		if claim, ok := claimed[rconPort]; ok {
+			return &PortConflict{Port: rconPort, Rcon: true, ServerId: claim.ServerId, ServerName: claim.ServerName}
+		}
+	}
+
+	// a running server holds its own port, which is no conflict when only its host
+	// is being changed
+	if portBound(host, port) {
+		if conflict := portOwner(port); conflict.ServerId != id {
+			return conflict
+		}
+	}
+	return nil
+}
+
+// portBound reports whether something on the host already listens on a port
+func portBound(host string, port int) bool {
+	if host == "0.0.0.0" {
+		host = ""
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return true
+	}
+	_ = listener.Close()
+	return false
+}
+
+// portOwner finds the process listening on a port, naming the server when it is
+// one of ours
+func portOwner(port int) *PortConflict {
+	conflict := &PortConflict{Port: port}
+
+	connections, err := gnet.Connections("tcp")
+	if err != nil {
+		zap.L().Warn("failed to list connections", zap.Error(err))
+		return conflict
+	}
+
+	for _, conn := range connections {
+		if conn.Status != "LISTEN" || int(conn.Laddr.Port) != port || conn.Pid == 0 {
+			continue
+		}
+		conflict.Pid = conn.Pid
+
+		for _, s := range activeServers.List() {
+			if s.PID == int(conn.Pid) {
+				conflict.ServerId, conflict.ServerName = s.ID, s.Name
+				return conflict
+			}
+		}
+		if p, err := process.NewProcess(conn.Pid); err == nil {
+			conflict.Process, _ = p.Name()
+		}
+		return conflict
+	}
+	return conflict
+}
+
+// claimedPorts maps the game and RCON ports of every registered server, except
+// the one with the given id, to the conflict a server wanting them runs into. With
+// onlineOnly set only running servers count.
+func claimedPorts(id string, onlineOnly bool) (map[int]*PortConflict, error) {
+	all, err := Repository().List()
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := make(map[int]*PortConflict)
+	for _, s := range all {
+		if s.Id == id || (onlineOnly && !activeServers.IsOnline(s.Id)) {
+			continue
+		}
+		claimed[s.Port] = &PortConflict{Port: s.Port, ServerId: s.Id, ServerName: s.Name}
+		if config, err := LoadServerConfig(s.Id); err == nil && config.Rcon.Port != 0 {
+			claimed[config.Rcon.Port] = &PortConflict{Port: config.Rcon.Port, Rcon: true, ServerId: s.Id, ServerName: s.Name}
+		}
+	}
+	return claimed, nil
+}
+
+// SuggestPort returns the lowest port in the configured range that no server
+// claims, either as its game or its RCON port, and nothing on the host listens on.
+// The RCON port a new server would get is checked the same way.
+func SuggestPort(host string) (int, error) {
+	settings, err := internal.LoadSettings()
+	if err != nil {
+		return 0, err
+	}
+	start, end := settings.GetPortRange()
+
+	claimed, err := claimedPorts("", false)
+	if err != nil {
+		return 0, err
+	}
+
+	for port := start; port <= end; port++ {
+		rconPort := port + rconPortOffset
+		if _, ok := claimed[port]; ok || portBound(host, port) {
+			continue
+		}
+		if _, ok := claimed[rconPort]; ok || portBound(host, rconPort) {
+			continue
+		}
+		return port, nil
+	}
+	return 0, ErrNoFreePort
+}
+
+// allocateRconPort picks the RCON port for a server with the given game port. It is
+// the game port plus rconPortOffset when that one is free, otherwise the next port
+// above it that no other server claims and nothing on the host listens on.
+func allocateRconPort(id string, host string, port int) (int, error) {
+	claimed, err := claimedPorts(id, false)
+	if err != nil {
+		return 0, err
+	}
+
+	for candidate := port + rconPortOffset; candidate <= 65535; candidate++ {
+		if _, ok := claimed[candidate]; ok || candidate == port || portBound(host, candidate) {
+			continue
+		}
+		return candidate, nil
+	}
+	return 0, ErrNoFreePort
+}
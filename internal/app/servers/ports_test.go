@@ -0,0 +1,47 @@
+package servers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckPortClaims(t *testing.T) {
+	repo := useMemoryRepository(t)
+	smp, err := repo.Create(Server{Id: "smp", Name: "Survival", Port: 41565, Host: "127.0.0.1", Version: "1.21.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InitServer(*smp); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadServerConfig("smp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		port int
+		rcon bool
+	}{
+		{"game port of another server", smp.Port, false},
+		{"rcon port of another server", config.Rcon.Port, true},
+		{"own rcon port would be another server's game port", smp.Port - rconPortOffset, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPort("creative", "127.0.0.1", tt.port)
+			var conflict *PortConflict
+			if !errors.As(err, &conflict) {
+				t.Fatalf("got %v, want a PortConflict", err)
+			}
+			if conflict.ServerId != "smp" || conflict.ServerName != "Survival" || conflict.Rcon != tt.rcon {
+				t.Fatalf("got %+v", conflict)
+			}
+		})
+	}
+
+	if err := CheckPort("creative", "127.0.0.1", smp.Port+100); err != nil {
+		t.Fatalf("free port: got %v", err)
+	}
+}
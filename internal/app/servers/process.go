@@ -0,0 +1,301 @@
+package servers
+
+import (
+	"bufio"
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/xDefyingGravity/gomcserver"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+	"watercolormc/internal"
+	"watercolormc/internal/app/channels"
+	activeServers "watercolormc/internal/app/servers/active"
+	"watercolormc/internal/utils"
+)
+
+// ProcessRecord is written to .watercolor/process.bin while a server is running so
+// that a restarted manager can find the JVM again.
+type ProcessRecord struct {
+	PID        int    `msgpack:"pid"`
+	CreateTime int64  `msgpack:"createTime"`
+	StartedAt  int64  `msgpack:"startedAt"`
+	ConsoleLog string `msgpack:"consoleLog"`
+}
+
+func processRecordPath(id string) string {
+	serverFolder := utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + id)
+	return filepath.Join(serverFolder, ".watercolor", "process.bin")
+}
+
+func saveProcessRecord(id string, record *ProcessRecord) error {
+	data, err := msgpack.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	path := processRecordPath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func loadProcessRecord(id string) (*ProcessRecord, error) {
+	path := processRecordPath(id)
+	if !utils.IsFileExists(path) {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var record ProcessRecord
+	if err := msgpack.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func removeProcessRecord(id string) {
+	if err := utils.RemoveIfExists(processRecordPath(id)); err != nil {
+		zap.L().Error("failed to remove process record", zap.String("id", id), zap.Error(err))
+	}
+}
+
+// processCreateTime returns the creation time of a process in unix milliseconds,
+// which together with the pid identifies it even if the pid is later reused.
+func processCreateTime(pid int) (int64, error) {
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return 0, err
+	}
+	return p.CreateTime()
+}
+
+func processStats(pid int) (*gomcserver.ServerStats, error) {
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return nil, err
+	}
+	cpu, err := p.Percent(time.Second)
+	if err != nil {
+		return nil, err
+	}
+	memInfo, err := p.MemoryInfo()
+	if err != nil {
+		return nil, err
+	}
+	threads, err := p.NumThreads()
+	if err != nil {
+		return nil, err
+	}
+	createTime, err := p.CreateTime()
+	if err != nil {
+		return nil, err
+	}
+
+	return &gomcserver.ServerStats{
+		CPUPercent:  cpu,
+		MemoryMB:    float64(memInfo.RSS) / (1024 * 1024),
+		ThreadCount: threads,
+		Uptime:      time.Since(time.UnixMilli(createTime)),
+	}, nil
+}
+
+func recordProcess(s activeServers.Server) error {
+	createTime, err := processCreateTime(s.PID)
+	if err != nil {
+		return err
+	}
+
+	return saveProcessRecord(s.ID, &ProcessRecord{
+		PID:        s.PID,
+		CreateTime: createTime,
+		StartedAt:  s.StartedAt.UnixMilli(),
+		ConsoleLog: filepath.Join(s.Instance.Directory, "logs", "latest.log"),
+	})
+}
+
+// Reattach looks for servers that were left running by a previous manager process
+// and registers them again so their stats, console and offline detection resume.
+func Reattach() error {
+	candidates, err := Repository().List()
+	if err != nil {
+		return err
+	}
+
+	for _, candidate := range candidates {
+		record, err := loadProcessRecord(candidate.Id)
+		if err != nil {
+			zap.L().Error("failed to load process record", zap.String("id", candidate.Id), zap.Error(err))
+			continue
+		}
+		if record == nil {
+			continue
+		}
+
+		createTime, err := processCreateTime(record.PID)
+		if err != nil || createTime != record.CreateTime {
+			zap.L().Info("discarding stale process record", zap.String("id", candidate.Id), zap.Int("pid", record.PID))
+			removeProcessRecord(candidate.Id)
+			continue
+		}
+
+		reattachServer(candidate, record)
+	}
+
+	return nil
+}
+
+func reattachServer(srv Server, record *ProcessRecord) {
+	server := gomcserver.NewServer(srv.Name, srv.Version)
+	server.Directory = utils.ExpandHome(internal.WatercolorDirectory + "/servers/" + srv.Id)
+
+	consoleLog := record.ConsoleLog
+	if consoleLog == "" {
+		consoleLog = filepath.Join(server.Directory, "logs", "latest.log")
+	}
+
+	// the server may still be starting, and players may be online already
+	state, players := scanConsoleLog(consoleLog)
+	server.Players = players
+
+	s := activeServers.Server{
+		ID:          srv.Id,
+		Name:        srv.Name,
+		Port:        srv.Port,
+		Host:        srv.Host,
+		Version:     srv.Version,
+		Description: srv.Description,
+		CreatedAt:   srv.CreatedAt,
+		PID:         record.PID,
+		StartedAt:   time.UnixMilli(record.StartedAt),
+		Reattached:  true,
+		Console:     openConsole(srv.Id),
+		Done:        make(chan struct{}),
+		State:       state,
+		Instance:    server,
+	}
+	if state == activeServers.StateStopping {
+		s.StopReason = StopReasonRequested
+	}
+	activeServers.Add(s)
+	startTickMonitor(s)
+
+	zap.L().Info("reattached to running server", zap.String("id", s.ID), zap.Int("pid", s.PID))
+
+	// the stdin pipe was lost with the previous manager, commands go over RCON instead
+	channels.SetListener("server:stdin:"+s.ID, func(msg string, client channels.Client) error {
+		if _, err := rconCommand(s.ID, msg); err != nil {
+			return err
+		}
+		recordCommand(s.ID, msg, CommandSourceConsole, client)
+		return nil
+	})
+
+	go tailLog(consoleLog, s.Done, makeLogListener("stdout", s.Console, s.ID))
+	go watchServer(s)
+}
+
+// scanConsoleLog reads the log of a server's current run to find out what it is up
+// to: running once "Done (" was logged, as markReady does, starting before that,
+// along with the players online. Without a log nothing would ever mark the server
+// ready, so it is taken to be running.
+func scanConsoleLog(path string) (activeServers.State, []string) {
+	players := []string{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		zap.L().Warn("failed to read console log", zap.String("path", path), zap.Error(err))
+		return activeServers.StateRunning, players
+	}
+	defer file.Close()
+
+	state := activeServers.StateStarting
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineSize)
+	for scanner.Scan() {
+		event := ParseConsoleEvent(scanner.Text())
+		if event == nil {
+			continue
+		}
+		switch event.Type {
+		case EventReady:
+			state = activeServers.StateRunning
+		case EventStopping:
+			state = activeServers.StateStopping
+		case EventJoin, EventLeave:
+			players = trackPlayer(players, event)
+		}
+	}
+	return state, players
+}
+
+// trackPlayer updates a player list with a join or leave
+func trackPlayer(players []string, event *ConsoleEvent) []string {
+	for i, player := range players {
+		if player == event.Player {
+			players = append(players[:i], players[i+1:]...)
+			break
+		}
+	}
+	if event.Type == EventJoin {
+		players = append(players, event.Player)
+	}
+	return players
+}
+
+// tailLog follows a log file from its current end, handing new output to fn until done
+// is closed. It is the console source for reattached servers whose stdout pipe was lost.
+func tailLog(path string, done <-chan struct{}, fn func(string)) {
+	var offset int64
+	if info, err := os.Stat(path); err == nil {
+		offset = info.Size()
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	buf := make([]byte, 4096)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+
+		if info, err := file.Stat(); err == nil && info.Size() < offset {
+			// the log was rotated, start over from the top of the new file
+			offset = 0
+		}
+
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			_ = file.Close()
+			continue
+		}
+
+		for {
+			n, err := file.Read(buf)
+			if n > 0 {
+				offset += int64(n)
+				fn(string(buf[:n]))
+			}
+			if err != nil {
+				break
+			}
+		}
+		_ = file.Close()
+	}
+}
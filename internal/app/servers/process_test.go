@@ -0,0 +1,48 @@
+package servers
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	activeServers "watercolormc/internal/app/servers/active"
+)
+
+func TestScanConsoleLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		log     string
+		state   activeServers.State
+		players []string
+	}{
+		{
+			name:    "still starting",
+			log:     "[12:00:00] [Server thread/INFO]: Preparing level \"world\"\n",
+			state:   activeServers.StateStarting,
+			players: []string{},
+		},
+		{
+			name: "running with players",
+			log: "[12:00:00] [Server thread/INFO]: Done (3.2s)! For help, type \"help\"\n" +
+				"[12:01:00] [Server thread/INFO]: Alex joined the game\n" +
+				"[12:02:00] [Server thread/INFO]: Steve joined the game\n" +
+				"[12:03:00] [Server thread/INFO]: Alex left the game\n",
+			state:   activeServers.StateRunning,
+			players: []string{"Steve"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "latest.log")
+			if err := os.WriteFile(path, []byte(tt.log), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			state, players := scanConsoleLog(path)
+			if state != tt.state || !reflect.DeepEqual(players, tt.players) {
+				t.Fatalf("got %s %v, want %s %v", state, players, tt.state, tt.players)
+			}
+		})
+	}
+}
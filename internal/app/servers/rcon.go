@@ -0,0 +1,199 @@
+package servers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/magiconair/properties"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"watercolormc/internal/app/channels"
+	activeServers "watercolormc/internal/app/servers/active"
+	"watercolormc/internal/rcon"
+	"watercolormc/internal/utils"
+)
+
+const (
+	// RCON preferably listens next to the game port, as on a default server (25565
+	// and 25575); see allocateRconPort
+	rconPortOffset = 10
+	rconTimeout    = 5 * time.Second
+)
+
+// RconSettings is how the manager reaches a server's RCON. The password is never
+// sent to clients: RCON is reachable from the network and grants full console
+// access, which PermSettings alone must not.
+type RconSettings struct {
+	Port     int    `msgpack:"port"`
+	Password string `msgpack:"password" json:"-"`
+}
+
+var (
+	rconMu      sync.Mutex
+	rconClients = make(map[string]*rcon.Client)
+)
+
+func newRconSettings(server Server) (RconSettings, error) {
+	port, err := allocateRconPort(server.Id, server.Host, server.Port)
+	if err != nil {
+		return RconSettings{}, err
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return RconSettings{}, err
+	}
+	return RconSettings{Port: port, Password: hex.EncodeToString(secret)}, nil
+}
+
+// ensureRconSettings gives servers created before RCON was configured automatically
+// their own port and password, and moves RCON to a free port when another server
+// or process has taken the one it had.
+func ensureRconSettings(server Server, config *ServerConfig) error {
+	if config.Rcon.Password != "" && config.Rcon.Port != 0 {
+		claimed, err := claimedPorts(server.Id, false)
+		if err != nil {
+			return err
+		}
+		_, taken := claimed[config.Rcon.Port]
+		if !taken && config.Rcon.Port != server.Port && !portBound(server.Host, config.Rcon.Port) {
+			return nil
+		}
+	}
+
+	settings, err := newRconSettings(server)
+	if err != nil {
+		return err
+	}
+	if config.Rcon.Password != "" {
+		settings.Password = config.Rcon.Password
+	}
+	config.Rcon = settings
+	return SaveServerConfig(server.Id, config)
+}
+
+// rconProperties enables RCON. It listens on the same address as the game,
+// server-ip, since vanilla servers have no setting to bind it elsewhere. With the
+// default host of 0.0.0.0 the port is reachable from the network and only the
+// generated password protects it.
+func rconProperties(settings RconSettings) map[string]string {
+	return map[string]string{
+		"enable-rcon":           "true",
+		"rcon.port":             strconv.Itoa(settings.Port),
+		"rcon.password":         settings.Password,
+		"broadcast-rcon-to-ops": "false",
+	}
+}
+
+// writeRconProperties enables RCON in a server's server.properties, creating the
+// file if the server has not generated it yet.
+func writeRconProperties(directory string, settings RconSettings) error {
+	propertiesFile := filepath.Join(directory, "server.properties")
+
+	props := properties.NewProperties()
+	if utils.IsFileExists(propertiesFile) {
+		data, err := os.ReadFile(propertiesFile)
+		if err != nil {
+			return err
+		}
+		if err := props.Load(data, properties.UTF8); err != nil {
+			return err
+		}
+	}
+
+	for key, value := range rconProperties(settings) {
+		if _, _, err := props.Set(key, value); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(propertiesFile, []byte(props.String()), 0644)
+}
+
+func rconAddress(id string, settings RconSettings) string {
+	host := "127.0.0.1"
+	if s, ok := activeServers.Get(id); ok && s.Host != "" && s.Host != "0.0.0.0" {
+		host = s.Host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(settings.Port))
+}
+
+func rconClient(id string) (*rcon.Client, error) {
+	rconMu.Lock()
+	defer rconMu.Unlock()
+
+	if client, ok := rconClients[id]; ok {
+		return client, nil
+	}
+
+	config, err := LoadServerConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	if config.Rcon.Password == "" {
+		return nil, errors.New("rcon is not configured for this server")
+	}
+
+	client, err := rcon.Dial(rconAddress(id, config.Rcon), config.Rcon.Password, rconTimeout)
+	if err != nil {
+		return nil, err
+	}
+	rconClients[id] = client
+	return client, nil
+}
+
+// rconCommand runs a command over the server's RCON connection, reconnecting once
+// if the cached connection turns out to be broken.
+func rconCommand(id string, command string) (string, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		client, err := rconClient(id)
+		if err != nil {
+			return "", err
+		}
+
+		output, err := client.Command(command)
+		if err == nil {
+			return output, nil
+		}
+		lastErr = err
+		closeRcon(id)
+	}
+	return "", lastErr
+}
+
+func closeRcon(id string) {
+	rconMu.Lock()
+	defer rconMu.Unlock()
+
+	if client, ok := rconClients[id]; ok {
+		_ = client.Close()
+		delete(rconClients, id)
+	}
+}
+
+// SendCommand runs a console command on a running server and returns its output.
+func SendCommand(id string, command string, client channels.Client) (string, error) {
+	s, ok := activeServers.Get(id)
+	if !ok {
+		return "", errors.New("server not found")
+	}
+	if s.State != activeServers.StateRunning {
+		return "", errors.New("server is " + string(s.State))
+	}
+
+	output, err := rconCommand(id, command)
+	if err != nil {
+		zap.L().Error("failed to run command over rcon", zap.String("id", id), zap.Error(err))
+		return "", err
+	}
+
+	zap.L().Info("ran command over rcon", zap.String("id", id), zap.String("command", command))
+	recordCommand(id, command, CommandSourceAPI, client)
+	return output, nil
+}
@@ -0,0 +1,244 @@
+package servers
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+	"watercolormc/internal/database"
+)
+
+var (
+	ErrServerNotFound = errors.New("server not found")
+	ErrServerExists   = errors.New("server already exists")
+)
+
+// ServerRepository stores the servers the manager knows about. Every read and write
+// of server records goes through it.
+type ServerRepository interface {
+	Get(id string) (*Server, error)
+	List() ([]Server, error)
+	Create(server Server) (*Server, error)
+	Update(server Server) error
+	Delete(id string) error
+}
+
+var (
+	repositoryMu sync.RWMutex
+	repository   ServerRepository = NewSQLiteServerRepository()
+)
+
+// Repository returns the repository in use
+func Repository() ServerRepository {
+	repositoryMu.RLock()
+	defer repositoryMu.RUnlock()
+	return repository
+}
+
+// SetRepository replaces the repository, e.g. with an in-memory one in tests
+func SetRepository(r ServerRepository) {
+	repositoryMu.Lock()
+	defer repositoryMu.Unlock()
+	repository = r
+}
+
+// SQLiteServerRepository keeps servers in the servers table
+type SQLiteServerRepository struct{}
+
+func NewSQLiteServerRepository() *SQLiteServerRepository {
+	return &SQLiteServerRepository{}
+}
+
+const serverColumns = `id, name, port, host, version, description, created_at`
+
+func scanServer(row interface{ Scan(...interface{}) error }) (*Server, error) {
+	var s Server
+	var description sql.NullString
+	var createdAt sql.NullTime
+	if err := row.Scan(&s.Id, &s.Name, &s.Port, &s.Host, &s.Version, &description, &createdAt); err != nil {
+		return nil, err
+	}
+	s.Description = description.String
+	if createdAt.Valid {
+		s.CreatedAt = createdAt.Time.Format(time.RFC3339)
+	}
+	return &s, nil
+}
+
+func (r *SQLiteServerRepository) client() (*sql.DB, error) {
+	db := database.Get()
+	if db == nil {
+		return nil, errors.New("database not initialized")
+	}
+	return db.Client, nil
+}
+
+func (r *SQLiteServerRepository) Get(id string) (*Server, error) {
+	db, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := scanServer(db.QueryRow(`SELECT `+serverColumns+` FROM servers WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrServerNotFound
+	}
+	return s, err
+}
+
+func (r *SQLiteServerRepository) List() ([]Server, error) {
+	db, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT ` + serverColumns + ` FROM servers ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	servers := []Server{}
+	for rows.Next() {
+		s, err := scanServer(rows)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, *s)
+	}
+	return servers, rows.Err()
+}
+
+func (r *SQLiteServerRepository) Create(server Server) (*Server, error) {
+	db, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Get(server.Id); err == nil {
+		return nil, ErrServerExists
+	} else if !errors.Is(err, ErrServerNotFound) {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO servers (id, name, port, host, version, description)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		server.Id, server.Name, server.Port, server.Host, server.Version, server.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Get(server.Id)
+}
+
+func (r *SQLiteServerRepository) Update(server Server) error {
+	db, err := r.client()
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(`
+		UPDATE servers SET name = ?, port = ?, host = ?, version = ?, description = ?
+		WHERE id = ?`,
+		server.Name, server.Port, server.Host, server.Version, server.Description, server.Id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrServerNotFound
+	}
+	return nil
+}
+
+func (r *SQLiteServerRepository) Delete(id string) error {
+	db, err := r.client()
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(`DELETE FROM servers WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrServerNotFound
+	}
+	return nil
+}
+
+// MemoryServerRepository keeps servers in memory, for tests that should not touch
+// the database
+type MemoryServerRepository struct {
+	mu      sync.RWMutex
+	servers map[string]Server
+}
+
+func NewMemoryServerRepository() *MemoryServerRepository {
+	return &MemoryServerRepository{servers: make(map[string]Server)}
+}
+
+func (r *MemoryServerRepository) Get(id string) (*Server, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	s, ok := r.servers[id]
+	if !ok {
+		return nil, ErrServerNotFound
+	}
+	return &s, nil
+}
+
+func (r *MemoryServerRepository) List() ([]Server, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	servers := make([]Server, 0, len(r.servers))
+	for _, s := range r.servers {
+		servers = append(servers, s)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].CreatedAt != servers[j].CreatedAt {
+			return servers[i].CreatedAt < servers[j].CreatedAt
+		}
+		return servers[i].Id < servers[j].Id
+	})
+	return servers, nil
+}
+
+func (r *MemoryServerRepository) Create(server Server) (*Server, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.servers[server.Id]; ok {
+		return nil, ErrServerExists
+	}
+	server.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	r.servers[server.Id] = server
+	return &server, nil
+}
+
+func (r *MemoryServerRepository) Update(server Server) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.servers[server.Id]
+	if !ok {
+		return ErrServerNotFound
+	}
+	server.CreatedAt = existing.CreatedAt
+	r.servers[server.Id] = server
+	return nil
+}
+
+func (r *MemoryServerRepository) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.servers[id]; !ok {
+		return ErrServerNotFound
+	}
+	delete(r.servers, id)
+	return nil
+}
@@ -0,0 +1,59 @@
+package servers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMemoryServerRepository(t *testing.T) {
+	repo := NewMemoryServerRepository()
+
+	created, err := repo.Create(Server{Id: "smp", Name: "smp", Port: 25565, Host: "0.0.0.0", Version: "1.21.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.CreatedAt == "" {
+		t.Fatal("created server has no creation time")
+	}
+	if _, err := repo.Create(Server{Id: "smp"}); !errors.Is(err, ErrServerExists) {
+		t.Fatalf("creating a duplicate: got %v, want ErrServerExists", err)
+	}
+
+	updated := *created
+	updated.Name = "survival"
+	updated.CreatedAt = ""
+	if err := repo.Update(updated); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.Get("smp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "survival" || got.CreatedAt != created.CreatedAt {
+		t.Fatalf("after update got %+v", got)
+	}
+
+	if _, err := repo.Create(Server{Id: "creative", Name: "creative"}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := repo.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Id != "creative" || list[1].Id != "smp" {
+		t.Fatalf("list is %+v, want creative and smp in order", list)
+	}
+
+	if err := repo.Delete("smp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get("smp"); !errors.Is(err, ErrServerNotFound) {
+		t.Fatalf("getting a deleted server: got %v, want ErrServerNotFound", err)
+	}
+	if err := repo.Update(updated); !errors.Is(err, ErrServerNotFound) {
+		t.Fatalf("updating a deleted server: got %v, want ErrServerNotFound", err)
+	}
+	if err := repo.Delete("smp"); !errors.Is(err, ErrServerNotFound) {
+		t.Fatalf("deleting a deleted server: got %v, want ErrServerNotFound", err)
+	}
+}
@@ -0,0 +1,211 @@
+package servers
+
+import (
+	"errors"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"watercolormc/internal"
+	activeServers "watercolormc/internal/app/servers/active"
+	"watercolormc/internal/database"
+	"watercolormc/internal/utils"
+)
+
+const (
+	RestartNever   = "never"
+	RestartOnCrash = "on-crash"
+	RestartAlways  = "always"
+
+	maxRestartBackoff = 5 * time.Minute
+)
+
+type RestartPolicy struct {
+	Mode        string `msgpack:"mode"`
+	MaxRestarts int    `msgpack:"maxRestarts"` // automatic restarts allowed within Window
+	Window      int    `msgpack:"window"`      // seconds
+	Backoff     int    `msgpack:"backoff"`     // seconds before the first restart, doubled for each further one
+}
+
+type RestartRecord struct {
+	Id          int64  `json:"id"`
+	ServerId    string `json:"serverId"`
+	Reason      string `json:"reason"`
+	ExitCode    *int   `json:"exitCode"`
+	Attempt     int    `json:"attempt"`
+	Delay       int    `json:"delay"`
+	RestartedAt string `json:"restartedAt"`
+}
+
+type restartInfo struct {
+	Attempt int `json:"attempt"`
+	Delay   int `json:"delay"`
+}
+
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		Mode:        RestartNever,
+		MaxRestarts: 3,
+		Window:      600,
+		Backoff:     5,
+	}
+}
+
+var (
+	restartMu      sync.Mutex
+	restartHistory = make(map[string][]time.Time)
+)
+
+// hasCrashed decides whether a server that exited on its own did so because of a
+// crash, using its exit code and any crash report written since it started.
+// Reattached servers are not our children and never have an exit code, so for them
+// only a crash report counts.
+func hasCrashed(s activeServers.Server, exitCode int) bool {
+	if exitCode != exitCodeUnknown && exitCode != 0 {
+		return true
+	}
+	return hasNewCrashReport(s.ID, s.StartedAt)
+}
+
+func hasNewCrashReport(id string, since time.Time) bool {
+	crashDir := filepath.Join(utils.ExpandHome(internal.WatercolorDirectory+"/servers/"+id), "crash-reports")
+	entries, err := os.ReadDir(crashDir)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// planRestart applies the server's restart policy to an exit and returns the restart
+// to perform, or nil if the server should stay offline.
+func planRestart(id string, reason string) *restartInfo {
+	if reason != StopReasonCrashed && reason != StopReasonExited {
+		return nil
+	}
+
+	config, err := LoadServerConfig(id)
+	if err != nil {
+		zap.L().Error("failed to load server config for restart policy", zap.String("id", id), zap.Error(err))
+		return nil
+	}
+
+	policy := config.RestartPolicy
+	switch policy.Mode {
+	case RestartAlways:
+	case RestartOnCrash:
+		if reason != StopReasonCrashed {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	restartMu.Lock()
+	defer restartMu.Unlock()
+
+	now := time.Now()
+	window := time.Duration(policy.Window) * time.Second
+	var recent []time.Time
+	for _, t := range restartHistory[id] {
+		if window <= 0 || now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+
+	if policy.MaxRestarts > 0 && len(recent) >= policy.MaxRestarts {
+		zap.L().Warn("restart limit reached, leaving server offline",
+			zap.String("id", id),
+			zap.Int("restarts", len(recent)),
+			zap.Int("window", policy.Window),
+		)
+		restartHistory[id] = recent
+		return nil
+	}
+
+	delay := time.Duration(policy.Backoff) * time.Second
+	for i := 0; i < len(recent) && delay < maxRestartBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRestartBackoff {
+		delay = maxRestartBackoff
+	}
+
+	restartHistory[id] = append(recent, now)
+	return &restartInfo{Attempt: len(recent) + 1, Delay: int(delay.Seconds())}
+}
+
+func performRestart(id string, name string, reason string, exitCode int, restart *restartInfo) {
+	if err := recordRestart(id, reason, exitCode, restart); err != nil {
+		zap.L().Error("failed to record restart", zap.String("id", id), zap.Error(err))
+	}
+
+	if internal.SendNotifications {
+		err := internal.Notify("A server has stopped unexpectedly!", "Restarting \""+name+"\" (attempt "+strconv.Itoa(restart.Attempt)+")")
+		if err != nil {
+			zap.L().Error("failed to send notification", zap.Error(err))
+		}
+	}
+
+	time.Sleep(time.Duration(restart.Delay) * time.Second)
+
+	zap.L().Info("restarting server", zap.String("id", id), zap.String("reason", reason), zap.Int("attempt", restart.Attempt))
+	if err := StartServer(id); err != nil {
+		zap.L().Error("failed to restart server", zap.String("id", id), zap.Error(err))
+	}
+}
+
+func recordRestart(id string, reason string, exitCode int, restart *restartInfo) error {
+	db := database.Get()
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+
+	var code *int
+	if exitCode != exitCodeUnknown {
+		code = &exitCode
+	}
+
+	_, err := db.Client.Exec(`
+		INSERT INTO server_restarts (server_id, reason, exit_code, attempt, delay_seconds)
+		VALUES (?, ?, ?, ?, ?)`, id, reason, code, restart.Attempt, restart.Delay)
+	return err
+}
+
+func GetServerRestarts(id string) ([]RestartRecord, error) {
+	db := database.Get()
+	if db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	rows, err := db.Client.Query(`
+		SELECT id, server_id, reason, exit_code, attempt, delay_seconds, restarted_at
+		FROM server_restarts WHERE server_id = ?
+		ORDER BY id DESC`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []RestartRecord{}
+	for rows.Next() {
+		var r RestartRecord
+		if err := rows.Scan(&r.Id, &r.ServerId, &r.Reason, &r.ExitCode, &r.Attempt, &r.Delay, &r.RestartedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
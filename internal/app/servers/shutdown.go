@@ -0,0 +1,77 @@
+package servers
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	activeServers "watercolormc/internal/app/servers/active"
+)
+
+var (
+	shutdownSignals = make(chan os.Signal, 1)
+	shuttingDown    atomic.Bool
+)
+
+// ShutdownSignals returns a channel that receives the signals asking the manager to
+// shut down.
+func ShutdownSignals() <-chan os.Signal {
+	signal.Notify(shutdownSignals, syscall.SIGINT, syscall.SIGTERM)
+	return shutdownSignals
+}
+
+// reclaimSignals drops the handlers gomcserver installs for every started server,
+// which would otherwise stop each server on its own as soon as the manager receives
+// SIGINT or SIGTERM, and hands those signals back to the manager.
+func reclaimSignals() {
+	signal.Reset(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	signal.Notify(shutdownSignals, syscall.SIGINT, syscall.SIGTERM)
+}
+
+// StopAll stops every active server in parallel and waits for them to exit. Servers
+// still running when the timeout expires are killed. No server can be started once
+// StopAll has been called.
+func StopAll(timeout time.Duration) {
+	shuttingDown.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, s := range activeServers.List() {
+		wg.Add(1)
+		go func(s activeServers.Server) {
+			defer wg.Done()
+
+			if s.State == activeServers.StateRunning || s.State == activeServers.StateStarting {
+				zap.L().Info("stopping server for shutdown", zap.String("id", s.ID))
+				if err := StopServer(s.ID); err != nil {
+					zap.L().Error("failed to stop server", zap.String("id", s.ID), zap.Error(err))
+				}
+			}
+
+			select {
+			case <-s.Done:
+				return
+			case <-ctx.Done():
+			}
+
+			zap.L().Warn("server did not stop before the shutdown deadline, killing it", zap.String("id", s.ID))
+			if err := KillServer(s.ID); err != nil {
+				zap.L().Error("failed to kill server", zap.String("id", s.ID), zap.Error(err))
+			}
+
+			select {
+			case <-s.Done:
+			case <-time.After(killTimeout):
+				zap.L().Error("server is still running after being killed", zap.String("id", s.ID))
+			}
+		}(s)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,192 @@
+package servers
+
+import (
+	"errors"
+	"github.com/xDefyingGravity/gomcserver"
+	"go.uber.org/zap"
+	"time"
+	"watercolormc/internal/database"
+)
+
+// Samples are kept at one second resolution for an hour, then averaged into one
+// minute buckets for a day and ten minute buckets for a month.
+var statsTiers = []struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}{
+	{time.Second, time.Hour},
+	{time.Minute, 24 * time.Hour},
+	{10 * time.Minute, 30 * 24 * time.Hour},
+}
+
+const statsCompactionInterval = time.Minute
+
+type StatsSample struct {
+	Time         time.Time `json:"time"`
+	CPUPercent   float64   `json:"cpuPercent"`
+	MemoryMB     float64   `json:"memoryMB"`
+	PeakMemoryMB float64   `json:"peakMemoryMB"`
+	ThreadCount  float64   `json:"threadCount"`
+	TPS          *float64  `json:"tps"`
+	MSPT         *float64  `json:"mspt"`
+	Samples      int       `json:"samples"`
+}
+
+func recordStats(id string, stats *gomcserver.ServerStats, ticks *TickStats) {
+	db := database.Get()
+	if db == nil {
+		return
+	}
+
+	var tps, mspt *float64
+	if ticks != nil {
+		tps, mspt = &ticks.TPS, ticks.MSPT
+	}
+
+	_, err := db.Client.Exec(`
+		INSERT OR REPLACE INTO server_stats (server_id, resolution, sampled_at, cpu_percent, memory_mb, peak_memory_mb, threads, tps, mspt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, int(statsTiers[0].Resolution.Seconds()), time.Now().Unix(),
+		stats.CPUPercent, stats.MemoryMB, stats.MemoryMB, stats.ThreadCount, tps, mspt,
+	)
+	if err != nil {
+		zap.L().Error("failed to record stats", zap.String("id", id), zap.Error(err))
+	}
+}
+
+// StartStatsCompaction downsamples and expires stored stats in the background.
+func StartStatsCompaction() {
+	go func() {
+		for {
+			if err := compactStats(time.Now()); err != nil {
+				zap.L().Error("failed to compact stats history", zap.Error(err))
+			}
+			time.Sleep(statsCompactionInterval)
+		}
+	}()
+}
+
+// compactStats folds every sample that outlived its tier into complete buckets of
+// the next tier and drops samples older than the last tier's retention.
+func compactStats(now time.Time) error {
+	db := database.Get()
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+
+	tx, err := db.Client.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < len(statsTiers)-1; i++ {
+		from := int64(statsTiers[i].Resolution.Seconds())
+		to := int64(statsTiers[i+1].Resolution.Seconds())
+		// only whole buckets are folded so none is ever written twice
+		cutoff := now.Add(-statsTiers[i].Retention).Unix() / to * to
+
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO server_stats (server_id, resolution, sampled_at, cpu_percent, memory_mb, peak_memory_mb, threads, tps, mspt, samples)
+			SELECT server_id, ?, (sampled_at / ?) * ?,
+				SUM(cpu_percent * samples) / SUM(samples),
+				SUM(memory_mb * samples) / SUM(samples),
+				MAX(peak_memory_mb),
+				SUM(threads * samples) / SUM(samples),
+				SUM(tps * samples) / SUM(CASE WHEN tps IS NOT NULL THEN samples END),
+				SUM(mspt * samples) / SUM(CASE WHEN mspt IS NOT NULL THEN samples END),
+				SUM(samples)
+			FROM server_stats
+			WHERE resolution = ? AND sampled_at < ?
+			GROUP BY server_id, sampled_at / ?`,
+			to, to, to, from, cutoff, to,
+		)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM server_stats WHERE resolution = ? AND sampled_at < ?`, from, cutoff); err != nil {
+			return err
+		}
+	}
+
+	last := statsTiers[len(statsTiers)-1]
+	if _, err := tx.Exec(`DELETE FROM server_stats WHERE sampled_at < ?`, now.Add(-last.Retention).Unix()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DefaultStatsResolution picks the finest resolution still stored for the whole
+// range, so that a graph does not mix densely and sparsely sampled periods.
+func DefaultStatsResolution(from time.Time) time.Duration {
+	age := time.Since(from)
+	for _, tier := range statsTiers {
+		if age <= tier.Retention {
+			return tier.Resolution
+		}
+	}
+	return statsTiers[len(statsTiers)-1].Resolution
+}
+
+// GetStatsHistory returns the stats of a server between from and to, averaged into
+// buckets of the given resolution. Periods that are only stored at a coarser
+// resolution come back at that resolution.
+func GetStatsHistory(id string, from time.Time, to time.Time, resolution time.Duration) ([]StatsSample, error) {
+	db := database.Get()
+	if db == nil {
+		return nil, errors.New("database not initialized")
+	}
+
+	step := int64(resolution.Seconds())
+	if step < 1 {
+		step = 1
+	}
+
+	rows, err := db.Client.Query(`
+		SELECT (sampled_at / ?) * ? AS bucket,
+			SUM(cpu_percent * samples) / SUM(samples),
+			SUM(memory_mb * samples) / SUM(samples),
+			MAX(peak_memory_mb),
+			SUM(threads * samples) / SUM(samples),
+			SUM(tps * samples) / SUM(CASE WHEN tps IS NOT NULL THEN samples END),
+			SUM(mspt * samples) / SUM(CASE WHEN mspt IS NOT NULL THEN samples END),
+			SUM(samples)
+		FROM server_stats
+		WHERE server_id = ? AND sampled_at >= ? AND sampled_at <= ?
+		GROUP BY bucket
+		ORDER BY bucket`,
+		step, step, id, from.Unix(), to.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []StatsSample{}
+	for rows.Next() {
+		var (
+			bucket int64
+			sample StatsSample
+		)
+		if err := rows.Scan(&bucket, &sample.CPUPercent, &sample.MemoryMB, &sample.PeakMemoryMB, &sample.ThreadCount, &sample.TPS, &sample.MSPT, &sample.Samples); err != nil {
+			return nil, err
+		}
+		sample.Time = time.Unix(bucket, 0)
+		samples = append(samples, sample)
+	}
+
+	return samples, rows.Err()
+}
+
+// DeleteStatsHistory drops the stored stats of a server that no longer exists.
+func DeleteStatsHistory(id string) error {
+	db := database.Get()
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+
+	_, err := db.Client.Exec(`DELETE FROM server_stats WHERE server_id = ?`, id)
+	return err
+}
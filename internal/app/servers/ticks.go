@@ -0,0 +1,233 @@
+package servers
+
+import (
+	"go.uber.org/zap"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	activeServers "watercolormc/internal/app/servers/active"
+	"watercolormc/internal/paper"
+)
+
+const (
+	tickProbeInterval = 5 * time.Second
+	// Paper samples are stale once a couple of probes went unanswered
+	tickSampleMaxAge = 3 * tickProbeInterval
+	// lag warnings are summed over this window to estimate the TPS of other servers
+	tickLagWindow = time.Minute
+
+	TickSourcePaper    = "paper"
+	TickSourceEstimate = "estimate"
+)
+
+var (
+	formattingPattern = regexp.MustCompile(`\x1b\[[0-9;]*m|§.`)
+	// TPS from last 1m, 5m, 15m: 20.0, 20.0, 20.0 (values above 20 are starred)
+	tpsPattern        = regexp.MustCompile(`TPS from last 1m, 5m, 15m: \*?([\d.]+), \*?([\d.]+), \*?([\d.]+)`)
+	msptHeaderPattern = regexp.MustCompile(`Server tick times \(avg/min/max\) from last 5s, 10s, 1m:`)
+	// ◴ 1.2/0.5/3.4, 1.1/0.5/3.4, 1.0/0.4/5.0
+	msptValuesPattern     = regexp.MustCompile(`([\d.]+)/[\d.]+/[\d.]+, [\d.]+/[\d.]+/[\d.]+, [\d.]+/[\d.]+/[\d.]+`)
+	unknownCommandPattern = regexp.MustCompile(`^Unknown (?:or incomplete )?command|<--\[HERE\]$`)
+)
+
+type TickStats struct {
+	TPS    float64  `json:"tps"`
+	MSPT   *float64 `json:"mspt,omitempty"`
+	Source string   `json:"source"`
+}
+
+type lagSample struct {
+	At       time.Time
+	BehindMs int
+}
+
+// tickMonitor tracks how well a server keeps up with its tick rate. Paper servers
+// are asked for their TPS and MSPT periodically over RCON, whose answers never reach
+// the console or latest.log; for other servers the TPS is estimated from "Can't keep
+// up" warnings.
+type tickMonitor struct {
+	mu    sync.Mutex
+	paper bool
+
+	tps       *float64
+	mspt      *float64
+	sampledAt time.Time
+	noMSPT    bool
+
+	lags []lagSample
+}
+
+var (
+	tickMu       sync.Mutex
+	tickMonitors = make(map[string]*tickMonitor)
+)
+
+// startTickMonitor starts tracking the tick rate of a server until it exits.
+func startTickMonitor(s activeServers.Server) {
+	m := &tickMonitor{paper: paper.IsPaper(s.Version)}
+
+	tickMu.Lock()
+	tickMonitors[s.ID] = m
+	tickMu.Unlock()
+
+	if m.paper {
+		go m.probe(s)
+	}
+}
+
+func stopTickMonitor(id string) {
+	tickMu.Lock()
+	defer tickMu.Unlock()
+	delete(tickMonitors, id)
+}
+
+func getTickMonitor(id string) (*tickMonitor, bool) {
+	tickMu.Lock()
+	defer tickMu.Unlock()
+
+	m, ok := tickMonitors[id]
+	return m, ok
+}
+
+// currentTicks returns the latest tick stats of a server, or nil if none are known.
+func currentTicks(id string) *TickStats {
+	m, ok := getTickMonitor(id)
+	if !ok {
+		return nil
+	}
+	return m.current(time.Now())
+}
+
+// recordLag feeds a "Can't keep up" warning into the TPS estimate.
+func recordLag(id string, behindMs int) {
+	m, ok := getTickMonitor(id)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lags = append(m.lags, lagSample{At: time.Now(), BehindMs: behindMs})
+}
+
+func (m *tickMonitor) probe(s activeServers.Server) {
+	ticker := time.NewTicker(tickProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.Done:
+			return
+		case <-ticker.C:
+		}
+
+		current, ok := activeServers.Get(s.ID)
+		if !ok {
+			return
+		}
+		if current.State != activeServers.StateRunning {
+			continue
+		}
+
+		output, err := rconCommand(s.ID, "tps")
+		if err != nil {
+			zap.L().Debug("failed to probe tps", zap.String("id", s.ID), zap.Error(err))
+			continue
+		}
+		m.parseTPS(output)
+
+		m.mu.Lock()
+		probeMSPT := !m.noMSPT
+		m.mu.Unlock()
+		if !probeMSPT {
+			continue
+		}
+
+		output, err = rconCommand(s.ID, "mspt")
+		if err != nil {
+			zap.L().Debug("failed to probe mspt", zap.String("id", s.ID), zap.Error(err))
+			continue
+		}
+		m.parseMSPT(output)
+	}
+}
+
+// probeLines splits an RCON answer into lines without color codes
+func probeLines(output string) []string {
+	lines := strings.Split(formattingPattern.ReplaceAllString(output, ""), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return lines
+}
+
+// parseTPS reads the answer to the tps command
+func (m *tickMonitor) parseTPS(output string) {
+	for _, line := range probeLines(output) {
+		match := tpsPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if tps, err := strconv.ParseFloat(match[1], 64); err == nil {
+			m.mu.Lock()
+			m.tps = &tps
+			m.sampledAt = time.Now()
+			m.mu.Unlock()
+		}
+		return
+	}
+}
+
+// parseMSPT reads the answer to the mspt command, the values follow a header line
+func (m *tickMonitor) parseMSPT(output string) {
+	header := false
+	for _, line := range probeLines(output) {
+		switch {
+		case msptHeaderPattern.MatchString(line):
+			header = true
+		case header && msptValuesPattern.MatchString(line):
+			if mspt, err := strconv.ParseFloat(msptValuesPattern.FindStringSubmatch(line)[1], 64); err == nil {
+				m.mu.Lock()
+				m.mspt = &mspt
+				m.sampledAt = time.Now()
+				m.mu.Unlock()
+			}
+			return
+		case unknownCommandPattern.MatchString(line):
+			// older Paper builds have no mspt command, stop asking for it
+			m.mu.Lock()
+			m.noMSPT = true
+			m.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (m *tickMonitor) current(now time.Time) *TickStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.paper && m.tps != nil && now.Sub(m.sampledAt) <= tickSampleMaxAge {
+		return &TickStats{TPS: *m.tps, MSPT: m.mspt, Source: TickSourcePaper}
+	}
+
+	cutoff := now.Add(-tickLagWindow)
+	behind := 0
+	kept := m.lags[:0]
+	for _, lag := range m.lags {
+		if lag.At.After(cutoff) {
+			kept = append(kept, lag)
+			behind += lag.BehindMs
+		}
+	}
+	m.lags = kept
+
+	window := int(tickLagWindow / time.Millisecond)
+	if behind > window {
+		behind = window
+	}
+	return &TickStats{TPS: 20 * float64(window-behind) / float64(window), Source: TickSourceEstimate}
+}
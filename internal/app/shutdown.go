@@ -0,0 +1,53 @@
+package app
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"time"
+	"watercolormc/internal"
+	"watercolormc/internal/app/channels"
+	"watercolormc/internal/app/servers"
+	activeServers "watercolormc/internal/app/servers/active"
+	"watercolormc/internal/database"
+)
+
+var shutdownDone = make(chan struct{})
+
+// Wait blocks until a shutdown started by a signal has finished.
+func Wait() {
+	<-shutdownDone
+}
+
+// shutdown stops the API, then either stops every server or leaves them running for
+// reattachment, and finally releases the manager's own resources.
+func shutdown(app *fiber.App) {
+	defer close(shutdownDone)
+
+	log := zap.L()
+	log.Info("shutting down")
+
+	if err := app.ShutdownWithTimeout(5 * time.Second); err != nil {
+		log.Error("failed to shut down http server", zap.Error(err))
+	}
+
+	settings, err := internal.LoadSettings()
+	if err != nil {
+		log.Error("failed to load settings, using defaults", zap.Error(err))
+		settings = &internal.Settings{BasePath: internal.WatercolorDirectory}
+	}
+
+	if settings.LeaveServersRunning {
+		log.Info("leaving servers running", zap.Int("count", len(activeServers.List())))
+	} else {
+		servers.StopAll(settings.GetShutdownTimeout())
+	}
+
+	channels.Cleanup()
+
+	if err := database.Close(); err != nil {
+		log.Error("failed to close database", zap.Error(err))
+	}
+
+	log.Info("shutdown complete")
+	_ = log.Sync()
+}
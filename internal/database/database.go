@@ -2,8 +2,6 @@ package database
 
 import (
 	"database/sql"
-	"errors"
-	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
 	"path/filepath"
 	"sync"
@@ -38,7 +36,7 @@ func Init() error {
 
 	var err error
 	once.Do(func() {
-		db, e := sql.Open("sqlite3", dataSourceName)
+		db, e := sql.Open(driverName, dataSourceName)
 		if e != nil {
 			err = e
 			return
@@ -53,25 +51,12 @@ func Init() error {
 	return err
 }
 
-func SetupSchema() error {
+// Close closes the singleton Database instance
+func Close() error {
 	if dbInstance == nil {
-		return errors.New("database not initialized")
+		return nil
 	}
-
-	schema := `
-	CREATE TABLE IF NOT EXISTS servers (
-		id TEXT PRIMARY KEY NOT NULL,
-		name TEXT NOT NULL,
-		port INTEGER NOT NULL,
-		host TEXT NOT NULL,
-	    description TEXT DEFAULT '',
-	    version TEXT NOT NULL,
-	    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	`
-
-	_, err := dbInstance.Client.Exec(schema)
-	return err
+	return dbInstance.Client.Close()
 }
 
 // Get returns the singleton Database instance
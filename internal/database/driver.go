@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/mattn/go-sqlite3"
+	"watercolormc/internal/metrics"
+)
+
+const driverName = "sqlite3_watercolor"
+
+func init() {
+	sql.Register(driverName, &countingDriver{Driver: &sqlite3.SQLiteDriver{}})
+}
+
+// countingDriver wraps the SQLite driver to count failed operations for metrics.
+type countingDriver struct {
+	driver.Driver
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		metrics.IncDBErrors("open")
+		return nil, err
+	}
+	return &countingConn{Conn: conn}, nil
+}
+
+type countingConn struct {
+	driver.Conn
+}
+
+func count(op string, err error) error {
+	if err != nil && !errors.Is(err, driver.ErrSkip) {
+		metrics.IncDBErrors(op)
+	}
+	return err
+}
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	return stmt, count("prepare", err)
+}
+
+func (c *countingConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	preparer, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	stmt, err := preparer.PrepareContext(ctx, query)
+	return stmt, count("prepare", err)
+}
+
+func (c *countingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	beginner, ok := c.Conn.(driver.ConnBeginTx)
+	if !ok {
+		return nil, errors.New("driver does not support transactions with options")
+	}
+	tx, err := beginner.BeginTx(ctx, opts)
+	return tx, count("begin", err)
+}
+
+func (c *countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	result, err := execer.ExecContext(ctx, query, args)
+	return result, count("exec", err)
+}
+
+func (c *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	rows, err := queryer.QueryContext(ctx, query, args)
+	return rows, count("query", err)
+}
+
+func (c *countingConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return count("ping", pinger.Ping(ctx))
+	}
+	return nil
+}
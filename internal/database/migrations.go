@@ -0,0 +1,288 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"path/filepath"
+	"strings"
+	"time"
+	"watercolormc/internal"
+	"watercolormc/internal/utils"
+)
+
+// migration upgrades the schema by one version inside a transaction. Installs that
+// predate the migrations table already have some of these tables, so migrations
+// only create what is missing.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations must be appended to, never edited or reordered
+var migrations = []migration{
+	{1, "create servers", execSQL(`
+	CREATE TABLE IF NOT EXISTS servers (
+		id TEXT PRIMARY KEY NOT NULL,
+		name TEXT NOT NULL,
+		port INTEGER NOT NULL,
+		host TEXT NOT NULL,
+	    description TEXT DEFAULT '',
+	    version TEXT NOT NULL,
+	    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`)},
+	{2, "create server_restarts", execSQL(`
+	CREATE TABLE IF NOT EXISTS server_restarts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		exit_code INTEGER,
+		attempt INTEGER NOT NULL,
+		delay_seconds INTEGER NOT NULL,
+		restarted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`)},
+	{3, "create server_stats", execSQL(`
+	CREATE TABLE IF NOT EXISTS server_stats (
+		server_id TEXT NOT NULL,
+		resolution INTEGER NOT NULL,
+		sampled_at INTEGER NOT NULL,
+		cpu_percent REAL NOT NULL,
+		memory_mb REAL NOT NULL,
+		max_memory_mb REAL NOT NULL,
+		threads REAL NOT NULL,
+		samples INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY (server_id, resolution, sampled_at)
+	);
+
+	CREATE INDEX IF NOT EXISTS server_stats_time ON server_stats (server_id, sampled_at);
+	`)},
+	// server_stats tables created before tick stats were sampled lack tps and mspt;
+	// the columns are added here rather than in the CREATE above so those get them too
+	{4, "add tick stats", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "server_stats", "tps", "REAL"); err != nil {
+			return err
+		}
+		return addColumn(tx, "server_stats", "mspt", "REAL")
+	}},
+	{5, "create command_history", execSQL(`
+	CREATE TABLE IF NOT EXISTS command_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		server_id TEXT NOT NULL,
+		command TEXT NOT NULL,
+		source TEXT NOT NULL,
+		username TEXT NOT NULL DEFAULT '',
+		client_addr TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		executed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS command_history_server ON command_history (server_id, id);
+	`)},
+	{6, "create users, sessions and api_tokens", execSQL(`
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE,
+		password_hash TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		client_addr TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_used_at DATETIME
+	);
+	`)},
+	{7, "add user roles and server_grants", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "users", "role", "TEXT NOT NULL DEFAULT 'member'"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS server_grants (
+			user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			server_id TEXT NOT NULL,
+			permission TEXT NOT NULL,
+			PRIMARY KEY (user_id, server_id, permission)
+		);
+		`)
+		return err
+	}},
+	{8, "create audit_log", execSQL(`
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at INTEGER NOT NULL,
+		user_id INTEGER,
+		username TEXT NOT NULL DEFAULT '',
+		token_id INTEGER,
+		client_addr TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		server_id TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		method TEXT NOT NULL DEFAULT '',
+		path TEXT NOT NULL DEFAULT '',
+		params TEXT NOT NULL DEFAULT '',
+		status INTEGER NOT NULL DEFAULT 0,
+		result TEXT NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS audit_log_server ON audit_log (server_id, id);
+	CREATE INDEX IF NOT EXISTS audit_log_user ON audit_log (username, id);
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_log is append-only');
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_log is append-only');
+	END;
+	`)},
+	{9, "rename max_memory_mb to peak_memory_mb", func(tx *sql.Tx) error {
+		// the column always held the highest RSS in a bucket, not the heap limit
+		var exists int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('server_stats') WHERE name = 'max_memory_mb'`).Scan(&exists)
+		if err != nil || exists == 0 {
+			return err
+		}
+		_, err = tx.Exec(`ALTER TABLE server_stats RENAME COLUMN max_memory_mb TO peak_memory_mb`)
+		return err
+	}},
+}
+
+func execSQL(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// addColumn adds a column to a table unless it is already there
+func addColumn(tx *sql.Tx, table string, column string, definition string) error {
+	var exists int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
+
+// LatestVersion is the schema version this build migrates to
+func LatestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the version of the newest migration applied
+func SchemaVersion() (int, error) {
+	if dbInstance == nil {
+		return 0, errors.New("database not initialized")
+	}
+
+	var version int
+	err := dbInstance.Client.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Migrate brings the schema up to date. An existing database is copied before the
+// first pending migration runs, and a database migrated by a newer build is
+// refused rather than risk running against a schema this build does not know.
+func Migrate() error {
+	if dbInstance == nil {
+		return errors.New("database not initialized")
+	}
+
+	_, err := dbInstance.Client.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY NOT NULL,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	if err != nil {
+		return err
+	}
+
+	current, err := SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > LatestVersion() {
+		return fmt.Errorf("database schema version %d is newer than the latest known version %d, update watercolormc", current, LatestVersion())
+	}
+	if current == LatestVersion() {
+		return nil
+	}
+
+	if err := backupBeforeMigration(current); err != nil {
+		return fmt.Errorf("failed to back up database before migrating: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		zap.L().Info("applied database migration", zap.Int("version", m.version), zap.String("name", m.name))
+	}
+
+	return nil
+}
+
+func applyMigration(m migration) error {
+	tx, err := dbInstance.Client.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// backupBeforeMigration copies the database next to itself. Fresh databases, which
+// hold nothing but the migrations table, are not copied.
+func backupBeforeMigration(version int) error {
+	var tables int
+	err := dbInstance.Client.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'
+		AND name NOT IN ('schema_migrations', 'sqlite_sequence')`).Scan(&tables)
+	if err != nil || tables == 0 {
+		return err
+	}
+
+	name := fmt.Sprintf("%s.v%d-%s.bak", strings.TrimSuffix(internal.DatabaseName, ".db"), version, time.Now().Format("20060102-150405"))
+	backupPath := filepath.Join(utils.ExpandHome(internal.WatercolorDataDirectory), name)
+
+	// VACUUM INTO writes a consistent copy through the open connection
+	if _, err := dbInstance.Client.Exec(`VACUUM INTO ?`, backupPath); err != nil {
+		return err
+	}
+
+	zap.L().Info("backed up database before migrating", zap.Int("version", version), zap.String("path", backupPath))
+	return nil
+}
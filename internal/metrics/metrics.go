@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collector writes metrics that are gathered when the endpoint is scraped, such as
+// the state of every server.
+type Collector func(e *Encoder)
+
+// latency buckets in seconds, from fast API calls to server starts
+var httpBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+var (
+	mu           sync.Mutex
+	collectors   []Collector
+	httpRequests = make(map[string]*histogram)
+	dbErrors     = make(map[string]uint64)
+)
+
+// RegisterCollector adds a collector that runs on every scrape.
+func RegisterCollector(c Collector) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	collectors = append(collectors, c)
+}
+
+// ObserveHTTPRequest records the latency of a handled request. route is the
+// registered path pattern, not the requested path, to keep the label set bounded.
+func ObserveHTTPRequest(method string, route string, status int, duration time.Duration) {
+	key := labels("method", method, "route", route, "status", strconv.Itoa(status))
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	h, ok := httpRequests[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(httpBuckets))}
+		httpRequests[key] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range httpBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// IncDBErrors counts a failed database operation.
+func IncDBErrors(op string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	dbErrors[labels("op", op)]++
+}
+
+// Write renders every metric in the Prometheus text exposition format.
+func Write(w io.Writer) error {
+	e := &Encoder{w: w}
+
+	mu.Lock()
+	e.Header("watercolormc_http_request_duration_seconds", "histogram", "Latency of HTTP requests handled by the manager.")
+	for _, key := range sortedKeys(httpRequests) {
+		h := httpRequests[key]
+		for i, bound := range httpBuckets {
+			e.sample("watercolormc_http_request_duration_seconds_bucket", joinLabels(key, labels("le", formatFloat(bound))), float64(h.counts[i]))
+		}
+		e.sample("watercolormc_http_request_duration_seconds_bucket", joinLabels(key, labels("le", "+Inf")), float64(h.count))
+		e.sample("watercolormc_http_request_duration_seconds_sum", key, h.sum)
+		e.sample("watercolormc_http_request_duration_seconds_count", key, float64(h.count))
+	}
+
+	e.Header("watercolormc_db_errors_total", "counter", "Database operations that returned an error.")
+	for _, key := range sortedKeys(dbErrors) {
+		e.sample("watercolormc_db_errors_total", key, float64(dbErrors[key]))
+	}
+
+	registered := append([]Collector(nil), collectors...)
+	mu.Unlock()
+
+	for _, c := range registered {
+		c(e)
+	}
+
+	return e.err
+}
+
+// Encoder writes samples in the Prometheus text exposition format.
+type Encoder struct {
+	w   io.Writer
+	err error
+}
+
+// Header announces a metric family. It must precede the family's samples.
+func (e *Encoder) Header(name string, kind string, help string) {
+	e.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Sample writes one sample with labels given as alternating names and values.
+func (e *Encoder) Sample(name string, value float64, labelPairs ...string) {
+	e.sample(name, labels(labelPairs...), value)
+}
+
+func (e *Encoder) sample(name string, labelSet string, value float64) {
+	if labelSet != "" {
+		labelSet = "{" + labelSet + "}"
+	}
+	e.printf("%s%s %s\n", name, labelSet, formatFloat(value))
+}
+
+func (e *Encoder) printf(format string, args ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(a string, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
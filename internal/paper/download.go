@@ -4,8 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 )
 
+// IsPaper reports whether a stored server version is a Paper build. Paper versions
+// are stored as their download URL.
+func IsPaper(version string) bool {
+	return strings.Contains(version, "api.papermc.io/")
+}
+
 func GetDownloadURL(version string) (string, error) {
 	type VersionInfo struct {
 		Builds []int `json:"builds"`
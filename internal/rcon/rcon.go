@@ -0,0 +1,156 @@
+package rcon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	packetResponse = 0
+	packetCommand  = 2
+	packetAuth     = 3
+
+	// Minecraft answers unknown packet types with an error response carrying the
+	// same id, which marks the end of a response split over several packets.
+	packetTerminator = 100
+
+	maxPacketSize = 4096 + 14
+)
+
+var ErrAuthFailed = errors.New("rcon authentication failed")
+
+// Client is a Source RCON connection. It is safe for concurrent use, commands are
+// sent one at a time.
+type Client struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	timeout time.Duration
+	nextID  int32
+}
+
+// Dial connects to an RCON server and authenticates with password.
+func Dial(addr string, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{conn: conn, timeout: timeout, nextID: 1}
+	if err := c.auth(password); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Client) auth(password string) error {
+	id := c.id()
+	if err := c.write(id, packetAuth, password); err != nil {
+		return err
+	}
+
+	for {
+		respID, respType, _, err := c.read()
+		if err != nil {
+			return err
+		}
+		// some servers send an empty response value before the auth response
+		if respType != packetCommand {
+			continue
+		}
+		if respID == -1 || respID != id {
+			return ErrAuthFailed
+		}
+		return nil
+	}
+}
+
+// Command runs a command and returns its output.
+func (c *Client) Command(command string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.id()
+	terminator := c.id()
+	if err := c.write(id, packetCommand, command); err != nil {
+		return "", err
+	}
+	if err := c.write(terminator, packetTerminator, ""); err != nil {
+		return "", err
+	}
+
+	var output bytes.Buffer
+	for {
+		respID, respType, body, err := c.read()
+		if err != nil {
+			return "", err
+		}
+		if respID == terminator {
+			return output.String(), nil
+		}
+		if respID == id && respType == packetResponse {
+			output.WriteString(body)
+		}
+	}
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) id() int32 {
+	id := c.nextID
+	c.nextID++
+	return id
+}
+
+func (c *Client) write(id int32, packetType int32, body string) error {
+	if len(body)+14 > maxPacketSize {
+		return fmt.Errorf("rcon command too long: %d bytes", len(body))
+	}
+
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, int32(len(body)+10))
+	_ = binary.Write(&buf, binary.LittleEndian, id)
+	_ = binary.Write(&buf, binary.LittleEndian, packetType)
+	buf.WriteString(body)
+	buf.Write([]byte{0, 0})
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+func (c *Client) read() (int32, int32, string, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, 0, "", err
+	}
+
+	var size int32
+	if err := binary.Read(c.conn, binary.LittleEndian, &size); err != nil {
+		return 0, 0, "", err
+	}
+	if size < 10 || size > maxPacketSize {
+		return 0, 0, "", fmt.Errorf("invalid rcon packet size %d", size)
+	}
+
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(c.conn, packet); err != nil {
+		return 0, 0, "", err
+	}
+
+	id := int32(binary.LittleEndian.Uint32(packet[0:4]))
+	packetType := int32(binary.LittleEndian.Uint32(packet[4:8]))
+	body := string(bytes.TrimRight(packet[8:], "\x00"))
+
+	return id, packetType, body, nil
+}
@@ -4,10 +4,32 @@ import (
 	"github.com/vmihailenco/msgpack/v5"
 	"os"
 	"path/filepath"
+	"time"
+)
+
+const DefaultShutdownTimeout = 90 * time.Second
+
+// ports handed out to new servers unless the settings say otherwise
+const (
+	DefaultPortRangeStart = 25565
+	DefaultPortRangeEnd   = 25665
 )
 
 type Settings struct {
 	BasePath string `msgpack:"base_path"`
+
+	// LeaveServersRunning keeps servers alive when the manager exits so they can be
+	// reattached on the next start instead of being stopped. Servers run in a session
+	// of their own on Linux and macOS, so signals sent to the manager's terminal do
+	// not reach them. Under systemd the unit needs KillMode=process, otherwise
+	// stopping it kills every process in its cgroup. On Windows servers share the
+	// manager's console and are stopped with it.
+	LeaveServersRunning bool `msgpack:"leave_servers_running"`
+	ShutdownTimeout     int  `msgpack:"shutdown_timeout"` // seconds
+
+	// PortRangeStart and PortRangeEnd bound the ports suggested for new servers
+	PortRangeStart int `msgpack:"port_range_start"`
+	PortRangeEnd   int `msgpack:"port_range_end"`
 }
 
 func (s *Settings) GetBasePath() string {
@@ -21,6 +43,21 @@ func (s *Settings) SetBasePath(basePath string) {
 	s.BasePath = basePath
 }
 
+func (s *Settings) GetShutdownTimeout() time.Duration {
+	if s.ShutdownTimeout <= 0 {
+		return DefaultShutdownTimeout
+	}
+	return time.Duration(s.ShutdownTimeout) * time.Second
+}
+
+// GetPortRange returns the inclusive range of ports suggested for new servers
+func (s *Settings) GetPortRange() (int, int) {
+	if s.PortRangeStart <= 0 || s.PortRangeEnd < s.PortRangeStart || s.PortRangeEnd > 65535 {
+		return DefaultPortRangeStart, DefaultPortRangeEnd
+	}
+	return s.PortRangeStart, s.PortRangeEnd
+}
+
 func (s *Settings) Save() error {
 	data, err := msgpack.Marshal(s)
 	if err != nil {
@@ -4,22 +4,36 @@ import (
 	"go.uber.org/zap"
 	"watercolormc/internal"
 	"watercolormc/internal/app"
+	"watercolormc/internal/app/auth"
 	"watercolormc/internal/app/channels"
+	"watercolormc/internal/app/servers"
 	"watercolormc/internal/database"
 	"watercolormc/internal/logger"
 )
 
 func main() {
+	servers.RunLauncher()
+
 	log := logger.Init()
 
 	if err := database.Init(); err != nil {
 		log.Fatal(err.Error())
 	}
 
-	if err := database.SetupSchema(); err != nil {
+	if err := database.Migrate(); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if err := auth.EnsureAdmin(); err != nil {
 		log.Fatal(err.Error())
 	}
 
+	if err := servers.Reattach(); err != nil {
+		log.Error("failed to reattach running servers", zap.Error(err))
+	}
+
+	servers.StartStatsCompaction()
+
 	server := app.Init()
 	defer channels.Cleanup()
 
@@ -27,4 +41,6 @@ func main() {
 	if err := server.Listen(internal.PORT); err != nil {
 		log.Fatal(err.Error())
 	}
+
+	app.Wait()
 }